@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// artifactVersion is the version of the artifact_file document. It only
+// changes when a field is renamed, removed or changes meaning.
+const artifactVersion = 1
+
+// Verdicts of the quality gate in the artifact_file document
+const (
+	artifactPassed = "PASSED" // the quality gate was accepted
+	artifactFailed = "FAILED" // the quality gate was not accepted
+	artifactError  = "ERROR"  // the plugin could not reach a quality gate verdict
+)
+
+type (
+	// Artifact is the machine-readable result written to artifact_file. Every
+	// field is always present, null or empty when unknown, so downstream steps
+	// can read it without checking for keys.
+	Artifact struct {
+		Version         int           `json:"version"`
+		GeneratedAt     string        `json:"generatedAt"`
+		ProjectKey      string        `json:"projectKey"`
+		Branch          string        `json:"branch"`
+		PullRequest     string        `json:"pullRequest"`
+		AnalysisID      string        `json:"analysisId"`
+		ServerURL       string        `json:"serverUrl"`
+		ServerVersion   string        `json:"serverVersion"`
+		DashboardURL    string        `json:"dashboardUrl"`
+		Task            *ArtifactTask `json:"task"`            // null when no Compute Engine task was followed
+		ScannerExitCode *int          `json:"scannerExitCode"` // null when the scan was skipped
+		QualityGate     *ArtifactGate `json:"qualityGate"`     // null when no quality gate was read
+		Verdict         string        `json:"verdict"`         // PASSED or FAILED from the quality gate, ERROR when the step failed before it, empty when it was not read
+		Reason          string        `json:"reason"`
+		StepPassed      bool          `json:"stepPassed"` // the step succeeded, also with a failed gate when sonar_quality_enabled is false
+		ExitCode        int           `json:"exitCode"`
+		FailureCause    string        `json:"failureCause"` // SONAR_FAILURE_CAUSE, empty on success
+	}
+
+	// ArtifactTask is the Compute Engine task that processed the analysis.
+	ArtifactTask struct {
+		ID              string `json:"id"`
+		Status          string `json:"status"`
+		SubmittedAt     string `json:"submittedAt"`
+		StartedAt       string `json:"startedAt"`
+		ExecutedAt      string `json:"executedAt"`
+		ExecutionTimeMs int    `json:"executionTimeMs"`
+		ErrorMessage    string `json:"errorMessage"`
+	}
+
+	// ArtifactGate is the quality gate status, before and after the plugin settings.
+	ArtifactGate struct {
+		Name         string              `json:"name"`
+		ServerStatus string              `json:"serverStatus"`
+		Status       string              `json:"status"`
+		Conditions   []ArtifactCondition `json:"conditions"`
+	}
+
+	// ArtifactCondition is a condition of the quality gate.
+	ArtifactCondition struct {
+		Metric       string `json:"metric"`
+		Comparator   string `json:"comparator"`
+		Threshold    string `json:"threshold"`
+		Actual       string `json:"actual"`
+		ServerStatus string `json:"serverStatus"`
+		Policy       string `json:"policy"`
+		Verdict      string `json:"verdict"`
+	}
+)
+
+// setTask records the Compute Engine task.
+func (a *Artifact) setTask(task *TaskResponse) {
+	t := task.Task
+	a.Task = &ArtifactTask{
+		ID:              t.ID,
+		Status:          t.Status,
+		SubmittedAt:     t.SubmittedAt,
+		StartedAt:       t.StartedAt,
+		ExecutedAt:      t.ExecutedAt,
+		ExecutionTimeMs: t.ExecutionTimeMs,
+		ErrorMessage:    t.ErrorMessage,
+	}
+	if t.AnalysisID != "" {
+		a.AnalysisID = t.AnalysisID
+	}
+}
+
+// setGate records the evaluated quality gate.
+func (a *Artifact) setGate(gate GateResult) {
+	a.QualityGate = &ArtifactGate{
+		Name:         gate.Name(),
+		ServerStatus: gate.ServerStatus,
+		Status:       gate.Status,
+		Conditions:   []ArtifactCondition{},
+	}
+	for _, condition := range gate.Conditions {
+		a.QualityGate.Conditions = append(a.QualityGate.Conditions, ArtifactCondition{
+			Metric:       condition.MetricKey,
+			Comparator:   condition.Comparator,
+			Threshold:    condition.ErrorThreshold,
+			Actual:       condition.ActualValue,
+			ServerStatus: condition.Status,
+			Policy:       condition.Policy,
+			Verdict:      condition.Verdict,
+		})
+	}
+}
+
+// finish completes the artifact with the outcome of Exec and the fields the
+// run did not get to, taken from the configuration.
+func (a *Artifact) finish(config Config, err error, code int) {
+	a.Version = artifactVersion
+	a.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+	if a.ProjectKey == "" {
+		a.ProjectKey = config.Key
+	}
+	if a.Branch == "" && a.PullRequest == "" {
+		a.Branch, a.PullRequest = config.Branch, config.PRKey
+	}
+	if a.ServerURL == "" {
+		a.ServerURL = config.Host
+	}
+	if a.DashboardURL == "" && a.ServerURL != "" && a.ProjectKey != "" {
+		a.DashboardURL = a.ServerURL + sonarDashStatic + a.ProjectKey
+	}
+	a.ExitCode = code
+	a.StepPassed = err == nil
+	a.FailureCause = ""
+	if err != nil {
+		a.FailureCause = failureCause(err)
+		if a.FailureCause == causeQualityGate {
+			a.Verdict = artifactFailed
+		} else if a.Verdict == "" {
+			a.Verdict = artifactError
+		}
+		if a.Reason == "" {
+			a.Reason = err.Error()
+		}
+	}
+}
+
+// writeArtifact writes the artifact as indented JSON to path.
+func writeArtifact(path string, artifact *Artifact) error {
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating artifact directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Printf("==> Artifact written to %s\n", path)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactFinish(t *testing.T) {
+	config := Config{Key: "project", Host: "http://sonar", Branch: "main"}
+
+	artifact := Artifact{Verdict: artifactPassed}
+	artifact.finish(config, nil, 0)
+	if artifact.Version != artifactVersion || artifact.Verdict != artifactPassed || !artifact.StepPassed || artifact.FailureCause != "" {
+		t.Errorf("Unexpected artifact %+v", artifact)
+	}
+	if artifact.ProjectKey != "project" || artifact.Branch != "main" || artifact.DashboardURL != "http://sonar/dashboard?id=project" {
+		t.Errorf("Expected the configuration defaults, got %+v", artifact)
+	}
+
+	artifact = Artifact{Reason: "status ERROR means at least one condition failed"}
+	artifact.finish(config, &QualityGateError{Status: "ERROR"}, 1)
+	if artifact.Verdict != artifactFailed || artifact.StepPassed || artifact.FailureCause != causeQualityGate || artifact.ExitCode != 1 {
+		t.Errorf("Unexpected gate failure artifact %+v", artifact)
+	}
+	if artifact.Reason != "status ERROR means at least one condition failed" {
+		t.Errorf("Expected the verdict reason to be kept, got %q", artifact.Reason)
+	}
+
+	artifact = Artifact{}
+	artifact.finish(config, fmt.Errorf("task X is PENDING: %w", ErrWaitTimeout), 6)
+	if artifact.Verdict != artifactError || artifact.StepPassed || artifact.FailureCause != causeTimeout || artifact.Reason == "" {
+		t.Errorf("Unexpected error artifact %+v", artifact)
+	}
+
+	// A failed gate that does not block the step
+	artifact = Artifact{Verdict: artifactFailed}
+	artifact.finish(config, nil, 0)
+	if artifact.Verdict != artifactFailed || !artifact.StepPassed {
+		t.Errorf("Expected the gate verdict to be kept, got %+v", artifact)
+	}
+
+	// No quality gate was read
+	artifact = Artifact{}
+	artifact.finish(config, nil, 0)
+	if artifact.Verdict != "" || !artifact.StepPassed {
+		t.Errorf("Expected no verdict, got %+v", artifact)
+	}
+}
+
+func TestWriteArtifact(t *testing.T) {
+	artifact := Artifact{}
+	task := &TaskResponse{}
+	task.Task.ID = "task"
+	task.Task.AnalysisID = "analysis"
+	task.Task.Status = taskSuccess
+	task.Task.ExecutionTimeMs = 1200
+	artifact.setTask(task)
+	artifact.setGate(evaluateGate(testProject("ERROR",
+		Condition{Status: "ERROR", MetricKey: "new_coverage", Comparator: "LT", ErrorThreshold: "80", ActualValue: "62.5"},
+	), nil))
+	artifact.finish(Config{Key: "project"}, &QualityGateError{Status: "ERROR"}, 1)
+
+	path := filepath.Join(t.TempDir(), "out", "artifact.json")
+	if err := writeArtifact(path, &artifact); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "projectKey", "branch", "pullRequest", "analysisId", "serverVersion", "dashboardUrl",
+		"task", "scannerExitCode", "qualityGate", "verdict", "stepPassed", "exitCode", "failureCause"} {
+		if _, ok := document[key]; !ok {
+			t.Errorf("Expected key %s in %s", key, data)
+		}
+	}
+	if document["scannerExitCode"] != nil || document["analysisId"] != "analysis" || document["verdict"] != artifactFailed {
+		t.Errorf("Unexpected artifact %s", data)
+	}
+	gate := document["qualityGate"].(map[string]interface{})
+	conditions := gate["conditions"].([]interface{})
+	if gate["status"] != "ERROR" || len(conditions) != 1 || conditions[0].(map[string]interface{})["metric"] != "new_coverage" {
+		t.Errorf("Unexpected quality gate %v", gate)
+	}
+	if document["task"].(map[string]interface{})["executionTimeMs"] != float64(1200) {
+		t.Errorf("Unexpected task %v", document["task"])
+	}
+}
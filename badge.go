@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// badgeGate is the badges key of the quality gate status badge.
+const badgeGate = "quality_gate"
+
+// Badge colors, the same as the SonarQube and shields.io badges
+const (
+	badgeGreen       = "#4c1"
+	badgeYellowGreen = "#97ca00"
+	badgeYellow      = "#dfb317"
+	badgeOrange      = "#fe7d37"
+	badgeRed         = "#e05d44"
+	badgeGrey        = "#9f9f9f"
+	badgeBlue        = "#007ec6"
+)
+
+// badgeLabels are the labels of the rating badges.
+var badgeLabels = map[string]string{
+	badgeGate:                "quality gate",
+	"sqale_rating":           "maintainability",
+	"reliability_rating":     "reliability",
+	"security_rating":        "security",
+	"security_review_rating": "security review",
+}
+
+// Badge is an SVG badge to write, for the quality gate or a metric.
+type Badge struct {
+	Metric string // quality_gate or a metric key
+	Path   string
+}
+
+// parseBadges reads badges, a comma separated list of metric=path pairs such
+// as "quality_gate=badges/gate.svg,coverage=badges/coverage.svg".
+func parseBadges(value string) ([]Badge, error) {
+	badges := []Badge{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("badges entry %q must look like metric=path.svg: %w", entry, ErrConfig)
+		}
+		badges = append(badges, Badge{Metric: strings.TrimSpace(parts[0]), Path: strings.TrimSpace(parts[1])})
+	}
+	return badges, nil
+}
+
+// exportBadges renders the badges from the gate status and the measures of
+// the analysed component, and writes them to their paths.
+func exportBadges(ctx context.Context, client *SonarClient, badges []Badge, gate GateResult, component url.Values) error {
+	metrics := []string{}
+	for _, badge := range badges {
+		if badge.Metric != badgeGate {
+			metrics = append(metrics, badge.Metric)
+		}
+	}
+	values := map[string]string{}
+	if len(metrics) > 0 {
+		measures, err := client.Measures(ctx, component, metrics)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Unable to get the measures of the badges")
+		} else {
+			values = measures.Values()
+		}
+	}
+
+	fmt.Printf("==> Badges:\n")
+	for _, badge := range badges {
+		var svg string
+		if badge.Metric == badgeGate {
+			svg = gateBadge(gate.Status)
+		} else {
+			svg = metricBadge(badge.Metric, values[badge.Metric])
+		}
+		if dir := filepath.Dir(badge.Path); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("creating badge directory %s: %w", dir, err)
+			}
+		}
+		if err := os.WriteFile(badge.Path, []byte(svg), 0644); err != nil {
+			return fmt.Errorf("writing badge %s: %w", badge.Path, err)
+		}
+		fmt.Printf("%s: %s\n", badge.Metric, badge.Path)
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+// gateBadge renders the quality gate status badge.
+func gateBadge(status string) string {
+	switch status {
+	case verdictOK:
+		return renderBadge(badgeLabels[badgeGate], "passed", badgeGreen)
+	case verdictWarn:
+		return renderBadge(badgeLabels[badgeGate], "warning", badgeOrange)
+	case verdictError:
+		return renderBadge(badgeLabels[badgeGate], "failed", badgeRed)
+	}
+	return renderBadge(badgeLabels[badgeGate], "none", badgeGrey)
+}
+
+// metricBadge renders the badge of a metric: ratings as letters, coverage and
+// densities as percentages, anything else as the raw value.
+func metricBadge(metric string, value string) string {
+	label, ok := badgeLabels[metric]
+	if !ok {
+		label = strings.ReplaceAll(metric, "_", " ")
+	}
+	if value == "" {
+		return renderBadge(label, "unknown", badgeGrey)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return renderBadge(label, value, badgeBlue)
+	}
+
+	switch {
+	case strings.HasSuffix(metric, "_rating"):
+		colors := []string{badgeGreen, badgeYellowGreen, badgeYellow, badgeOrange, badgeRed}
+		rating := int(number+0.5) - 1
+		if rating < 0 || rating >= len(colors) {
+			return renderBadge(label, value, badgeGrey)
+		}
+		return renderBadge(label, string(rune('A'+rating)), colors[rating])
+	case strings.HasSuffix(metric, "coverage"):
+		color := badgeRed
+		if number >= 80 {
+			color = badgeGreen
+		} else if number >= 60 {
+			color = badgeYellow
+		}
+		return renderBadge(label, strconv.FormatFloat(number, 'f', 1, 64)+"%", color)
+	case strings.HasSuffix(metric, "_density"):
+		return renderBadge(label, strconv.FormatFloat(number, 'f', 1, 64)+"%", badgeBlue)
+	}
+	return renderBadge(label, value, badgeBlue)
+}
+
+// renderBadge returns a flat two part badge. Text widths are estimated from
+// the character count, which is close enough for the Verdana 11px font.
+func renderBadge(label string, value string, color string) string {
+	labelWidth := len(label)*7 + 10
+	valueWidth := len(value)*7 + 10
+	width := labelWidth + valueWidth
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, value)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, value)
+	b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`,
+		labelWidth, labelWidth, valueWidth, color, width)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth/2, label, labelWidth/2, label)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth+valueWidth/2, value, labelWidth+valueWidth/2, value)
+	b.WriteString(`</g></svg>`)
+	b.WriteString("\n")
+	return b.String()
+}
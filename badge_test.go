@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBadges(t *testing.T) {
+	badges, err := parseBadges("quality_gate=gate.svg, coverage = badges/coverage.svg")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(badges) != 2 || badges[1] != (Badge{Metric: "coverage", Path: "badges/coverage.svg"}) {
+		t.Errorf("Unexpected badges %+v", badges)
+	}
+	if _, err := parseBadges("coverage"); !errors.Is(err, ErrConfig) {
+		t.Errorf("Expected ErrConfig, got %v", err)
+	}
+}
+
+func TestMetricBadge(t *testing.T) {
+	tests := []struct {
+		metric, value, text, color string
+	}{
+		{"sqale_rating", "1.0", ">A<", badgeGreen},
+		{"security_rating", "5.0", ">E<", badgeRed},
+		{"coverage", "72.46", ">72.5%<", badgeYellow},
+		{"duplicated_lines_density", "3", ">3.0%<", badgeBlue},
+		{"ncloc", "", ">unknown<", badgeGrey},
+	}
+	for _, test := range tests {
+		svg := metricBadge(test.metric, test.value)
+		if !strings.Contains(svg, test.text) || !strings.Contains(svg, test.color) {
+			t.Errorf("%s=%s: expected %s in %s, got %s", test.metric, test.value, test.text, test.color, svg)
+		}
+	}
+	if svg := gateBadge("ERROR"); !strings.Contains(svg, ">failed<") || xml.Unmarshal([]byte(svg), new(interface{})) != nil {
+		t.Errorf("Expected a valid failed gate badge, got %s", svg)
+	}
+}
+
+func TestExportBadges(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			if req.URL.Query().Get("metricKeys") != "coverage" {
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"component":{"measures":[{"metric":"coverage","value":"91.0"}]}}`)),
+			}
+		}),
+	}
+
+	dir := t.TempDir()
+	badges := []Badge{
+		{Metric: badgeGate, Path: filepath.Join(dir, "badges", "gate.svg")},
+		{Metric: "coverage", Path: filepath.Join(dir, "coverage.svg")},
+	}
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	if err := exportBadges(context.Background(), client, badges, GateResult{Status: "OK"}, url.Values{"component": {"project"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	gate, _ := os.ReadFile(badges[0].Path)
+	coverage, _ := os.ReadFile(badges[1].Path)
+	if !strings.Contains(string(gate), ">passed<") || !strings.Contains(string(coverage), ">91.0%<") {
+		t.Errorf("Unexpected badges %s %s", gate, coverage)
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SonarQube Web API endpoints used by the plugin.
+const (
+	ceTaskPath            = "/api/ce/task"
+	projectStatusPath     = "/api/qualitygates/project_status"
+	projectAnalysesPath   = "/api/project_analyses/search"
+	authValidatePath      = "/api/authentication/validate"
+	ceCancelPath          = "/api/ce/cancel"
+	measuresComponentPath = "/api/measures/component"
+	gateByProjectPath     = "/api/qualitygates/get_by_project"
+	gateShowPath          = "/api/qualitygates/show"
+	measuresHistoryPath   = "/api/measures/search_history"
+	issuesSearchPath      = "/api/issues/search"
+	projectBranchesPath   = "/api/project_branches/list"
+	serverVersionPath     = "/api/server/version"
+	maxPageSize           = 500
+	authSchemeBasic       = "Basic"
+	authSchemeBearer      = "Bearer"
+	maxErrorBodyLogLength = 512
+)
+
+var (
+	// ErrUnauthorized is returned when the server rejects the token (HTTP 401 or 403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is returned when the requested resource does not exist (HTTP 404).
+	ErrNotFound = errors.New("not found")
+	// ErrServerError is returned when the server fails to handle the request (HTTP 5xx).
+	ErrServerError = errors.New("server error")
+	// ErrUnreachable is matched by transport failures: DNS, refused connections, TLS, timeouts.
+	ErrUnreachable = errors.New("server unreachable")
+)
+
+// DefaultRetryPolicy is used by clients unless the plugin configures another one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+type (
+	// RetryPolicy controls how idempotent requests are retried after transient
+	// failures: network errors and HTTP 429, 502, 503 and 504.
+	RetryPolicy struct {
+		MaxRetries     int
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+	}
+
+	// SonarClient talks to the SonarQube Web API on behalf of the plugin.
+	SonarClient struct {
+		BaseURL    string
+		Token      string
+		HTTPClient *http.Client
+		// AuthScheme is the Authorization scheme (Basic or Bearer) applied to
+		// every request. It is negotiated on the first request when empty.
+		AuthScheme string
+		Retry      RetryPolicy
+		// Retries counts the retried requests over the life of the client.
+		Retries int
+	}
+
+	// APIError is a non-2xx answer of the SonarQube Web API.
+	APIError struct {
+		Endpoint   string
+		StatusCode int
+		Messages   []string
+		// RetryAfter is the delay requested by the server in the Retry-After header.
+		RetryAfter time.Duration
+	}
+
+	// UnreachableError wraps a transport failure so it matches ErrUnreachable
+	// while keeping the original error available to errors.As.
+	UnreachableError struct {
+		Err error
+	}
+
+	// authValidateResponse Check credentials
+	authValidateResponse struct {
+		Valid bool `json:"valid"`
+	}
+
+	// apiErrorBody is the error payload returned by the Web API.
+	apiErrorBody struct {
+		Errors []struct {
+			Msg string `json:"msg"`
+		} `json:"errors"`
+	}
+)
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("sonarqube %s returned HTTP %d", e.Endpoint, e.StatusCode)
+	if len(e.Messages) > 0 {
+		msg += ": " + strings.Join(e.Messages, "; ")
+	}
+	return msg
+}
+
+func (e *UnreachableError) Error() string {
+	return "sonarqube server unreachable: " + e.Err.Error()
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnreachableError) Is(target error) bool {
+	return target == ErrUnreachable
+}
+
+// Unwrap lets callers match API errors with errors.Is against ErrUnauthorized,
+// ErrNotFound and ErrServerError.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServerError
+	}
+	return nil
+}
+
+// NewSonarClient returns a client for the server at baseURL, sharing netClient.
+func NewSonarClient(baseURL string, token string) *SonarClient {
+	return &SonarClient{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: netClient,
+		Retry:      DefaultRetryPolicy,
+	}
+}
+
+// Authenticate finds the Authorization scheme this server accepts for the
+// token (api/authentication/validate) and uses it for every later request.
+// Basic is tried first since every SonarQube version supports it, Bearer
+// covers servers and SonarCloud setups that only accept user tokens that way.
+func (c *SonarClient) Authenticate(ctx context.Context) (string, error) {
+	endpoint := c.BaseURL + authValidatePath
+	for _, scheme := range []string{authSchemeBasic, authSchemeBearer} {
+		body, err := c.send(ctx, http.MethodGet, endpoint, scheme)
+		if err != nil && !errors.Is(err, ErrUnauthorized) {
+			return "", err
+		}
+		var validation authValidateResponse
+		if err == nil && json.Unmarshal(body, &validation) == nil && validation.Valid {
+			c.AuthScheme = scheme
+			logrus.WithFields(logrus.Fields{
+				"scheme": scheme,
+				"server": c.BaseURL,
+			}).Info("SonarQube authentication scheme selected")
+			return scheme, nil
+		}
+		logrus.WithField("scheme", scheme).Debug("SonarQube rejected authentication scheme")
+	}
+	return "", fmt.Errorf("sonarqube rejected the token with both %s and %s authentication: %w",
+		authSchemeBasic, authSchemeBearer, ErrUnauthorized)
+}
+
+// Task returns the Compute Engine task with the given id (api/ce/task).
+// additionalFields may ask for "stacktrace" and "scannerContext".
+func (c *SonarClient) Task(ctx context.Context, id string, additionalFields ...string) (*TaskResponse, error) {
+	params := url.Values{"id": {id}}
+	if len(additionalFields) > 0 {
+		params.Set("additionalFields", strings.Join(additionalFields, ","))
+	}
+	task := &TaskResponse{}
+	if err := c.get(ctx, ceTaskPath, params, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// CancelTask drops a pending Compute Engine task from the queue (api/ce/cancel).
+// The token needs the Administer permission on the project.
+func (c *SonarClient) CancelTask(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodPost, c.BaseURL+ceCancelPath+"?"+url.Values{"id": {id}}.Encode())
+	return err
+}
+
+// ServerVersion returns the version of the server (api/server/version).
+func (c *SonarClient) ServerVersion(ctx context.Context) (string, error) {
+	body, err := c.do(ctx, http.MethodGet, c.BaseURL+serverVersionPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ProjectStatus returns the quality gate status selected by params, which must
+// hold one of analysisId, projectKey (+branch/pullRequest) or projectId
+// (api/qualitygates/project_status).
+func (c *SonarClient) ProjectStatus(ctx context.Context, params url.Values) (*Project, error) {
+	project := &Project{}
+	if err := c.get(ctx, projectStatusPath, params, project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// ProjectAnalyses returns the most recent analyses of a project, newest first
+// (api/project_analyses/search).
+func (c *SonarClient) ProjectAnalyses(ctx context.Context, projectKey string, pageSize int) (*AnalysisResponse, error) {
+	return c.SearchAnalyses(ctx, url.Values{"project": {projectKey}}, 1, pageSize)
+}
+
+// SearchAnalyses returns one page of the analyses selected by params, which
+// holds project and optionally branch or pullRequest, newest first
+// (api/project_analyses/search).
+func (c *SonarClient) SearchAnalyses(ctx context.Context, params url.Values, page int, pageSize int) (*AnalysisResponse, error) {
+	query := url.Values{
+		"p":  {strconv.Itoa(page)},
+		"ps": {strconv.Itoa(pageSize)},
+	}
+	for key, values := range params {
+		query[key] = values
+	}
+	analyses := &AnalysisResponse{}
+	if err := c.get(ctx, projectAnalysesPath, query, analyses); err != nil {
+		return nil, err
+	}
+	return analyses, nil
+}
+
+// ProjectBranches returns the branches of a project (api/project_branches/list).
+func (c *SonarClient) ProjectBranches(ctx context.Context, projectKey string) (*BranchesResponse, error) {
+	branches := &BranchesResponse{}
+	if err := c.get(ctx, projectBranchesPath, url.Values{"project": {projectKey}}, branches); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// Measures returns the given metrics of the component selected by params,
+// which holds component and optionally branch or pullRequest
+// (api/measures/component).
+func (c *SonarClient) Measures(ctx context.Context, params url.Values, metricKeys []string) (*MeasuresResponse, error) {
+	query := url.Values{"metricKeys": {strings.Join(metricKeys, ",")}}
+	for key, values := range params {
+		query[key] = values
+	}
+	measures := &MeasuresResponse{}
+	if err := c.get(ctx, measuresComponentPath, query, measures); err != nil {
+		return nil, err
+	}
+	return measures, nil
+}
+
+// MeasuresHistory returns the history of the given metrics for the component
+// selected by params, oldest first, reading every page
+// (api/measures/search_history).
+func (c *SonarClient) MeasuresHistory(ctx context.Context, params url.Values, metrics []string) (*MeasuresHistoryResponse, error) {
+	history := &MeasuresHistoryResponse{}
+	byMetric := map[string]int{}
+	for page := 1; ; page++ {
+		query := url.Values{
+			"metrics": {strings.Join(metrics, ",")},
+			"ps":      {strconv.Itoa(maxPageSize)},
+			"p":       {strconv.Itoa(page)},
+		}
+		for key, values := range params {
+			query[key] = values
+		}
+		response := &MeasuresHistoryResponse{}
+		if err := c.get(ctx, measuresHistoryPath, query, response); err != nil {
+			return nil, err
+		}
+		for _, measure := range response.Measures {
+			index, ok := byMetric[measure.Metric]
+			if !ok {
+				byMetric[measure.Metric] = len(history.Measures)
+				history.Measures = append(history.Measures, measure)
+				continue
+			}
+			history.Measures[index].History = append(history.Measures[index].History, measure.History...)
+		}
+		history.Paging = response.Paging
+		if response.Paging.PageIndex*response.Paging.PageSize >= response.Paging.Total || response.Paging.PageSize == 0 {
+			return history, nil
+		}
+	}
+}
+
+// SearchIssues returns the issues selected by params, reading every page up to
+// the 10000 issues the server allows (api/issues/search). Components and rules
+// of all pages are merged.
+func (c *SonarClient) SearchIssues(ctx context.Context, params url.Values) (*IssuesResponse, error) {
+	issues := &IssuesResponse{}
+	components := map[string]bool{}
+	rules := map[string]bool{}
+	for page := 1; ; page++ {
+		query := url.Values{
+			"ps": {strconv.Itoa(maxPageSize)},
+			"p":  {strconv.Itoa(page)},
+		}
+		for key, values := range params {
+			query[key] = values
+		}
+		response := &IssuesResponse{}
+		if err := c.get(ctx, issuesSearchPath, query, response); err != nil {
+			return nil, err
+		}
+		issues.Total = response.Total
+		if response.Paging.Total > 0 {
+			issues.Total = response.Paging.Total
+		}
+		issues.Issues = append(issues.Issues, response.Issues...)
+		for _, component := range response.Components {
+			if !components[component.Key] {
+				components[component.Key] = true
+				issues.Components = append(issues.Components, component)
+			}
+		}
+		for _, rule := range response.Rules {
+			if !rules[rule.Key] {
+				rules[rule.Key] = true
+				issues.Rules = append(issues.Rules, rule)
+			}
+		}
+		if len(response.Issues) == 0 || page*maxPageSize >= issues.Total || page*maxPageSize >= maxIssueResults {
+			return issues, nil
+		}
+	}
+}
+
+// QualityGateByProject returns the quality gate used by a project, organization
+// is only needed on SonarCloud (api/qualitygates/get_by_project).
+func (c *SonarClient) QualityGateByProject(ctx context.Context, projectKey string, organization string) (*QualityGateByProjectResponse, error) {
+	params := url.Values{"project": {projectKey}}
+	if organization != "" {
+		params.Set("organization", organization)
+	}
+	gate := &QualityGateByProjectResponse{}
+	if err := c.get(ctx, gateByProjectPath, params, gate); err != nil {
+		return nil, err
+	}
+	return gate, nil
+}
+
+// QualityGate returns the definition of a quality gate with its conditions
+// (api/qualitygates/show).
+func (c *SonarClient) QualityGate(ctx context.Context, name string, organization string) (*QualityGateDefinition, error) {
+	params := url.Values{"name": {name}}
+	if organization != "" {
+		params.Set("organization", organization)
+	}
+	gate := &QualityGateDefinition{}
+	if err := c.get(ctx, gateShowPath, params, gate); err != nil {
+		return nil, err
+	}
+	return gate, nil
+}
+
+// get performs a GET request and decodes the JSON answer into out.
+func (c *SonarClient) get(ctx context.Context, path string, params url.Values, out interface{}) error {
+	endpoint := c.BaseURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	body, err := c.do(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("sonarqube %s returned an empty response", path)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding sonarqube %s response: %w", path, err)
+	}
+	return nil
+}
+
+// do sends the request with the negotiated auth scheme and returns the
+// response body of a 2xx answer.
+func (c *SonarClient) do(ctx context.Context, method string, endpoint string) ([]byte, error) {
+	if c.AuthScheme == "" {
+		if _, err := c.Authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.send(ctx, method, endpoint, c.AuthScheme)
+}
+
+// send performs the request, retrying GET requests on transient failures
+// according to the client RetryPolicy.
+func (c *SonarClient) send(ctx context.Context, method string, endpoint string, scheme string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == authSchemeBearer {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Token, "")
+	}
+
+	for attempt := 0; ; attempt++ {
+		logrus.WithFields(logrus.Fields{
+			"method":   method,
+			"endpoint": endpoint,
+			"auth":     scheme,
+			"attempt":  attempt + 1,
+		}).Debug("SonarQube request")
+
+		body, err := c.sendOnce(req)
+		if err == nil || method != http.MethodGet || attempt >= c.Retry.MaxRetries || !isRetryable(ctx, err) {
+			return body, err
+		}
+
+		wait := c.Retry.backoff(attempt, err)
+		c.Retries++
+		logrus.WithFields(logrus.Fields{
+			"endpoint": req.URL.Path,
+			"error":    err,
+			"retry":    fmt.Sprintf("%d/%d", attempt+1, c.Retry.MaxRetries),
+			"wait":     wait,
+		}).Warn("SonarQube request failed, retrying")
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *SonarClient) sendOnce(req *http.Request) ([]byte, error) {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		return nil, &UnreachableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading sonarqube response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := newAPIError(req.URL.Path, resp.StatusCode, body)
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, apiErr
+	}
+	return body, nil
+}
+
+// isRetryable reports whether err is a transient failure worth another attempt.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Anything else comes from the transport: refused connections, resets, timeouts.
+	return true
+}
+
+// backoff returns the delay before retry number attempt+1. A Retry-After
+// sent by the server wins, up to MaxBackoff, otherwise the delay grows
+// exponentially from InitialBackoff up to MaxBackoff, with jitter so parallel
+// builds spread out.
+func (r RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if r.MaxBackoff > 0 && apiErr.RetryAfter > r.MaxBackoff {
+			return r.MaxBackoff
+		}
+		return apiErr.RetryAfter
+	}
+	delay := r.InitialBackoff << uint(attempt)
+	if delay <= 0 || (r.MaxBackoff > 0 && delay > r.MaxBackoff) {
+		delay = r.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func newAPIError(endpoint string, statusCode int, body []byte) *APIError {
+	apiErr := &APIError{Endpoint: endpoint, StatusCode: statusCode}
+	var payload apiErrorBody
+	if json.Unmarshal(body, &payload) == nil && len(payload.Errors) > 0 {
+		for _, e := range payload.Errors {
+			apiErr.Messages = append(apiErr.Messages, e.Msg)
+		}
+	} else if len(body) > 0 {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > maxErrorBodyLogLength {
+			msg = msg[:maxErrorBodyLogLength] + "..."
+		}
+		apiErr.Messages = append(apiErr.Messages, msg)
+	}
+	return apiErr
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSonarClientProjectStatus(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			if req.URL.Path != projectStatusPath {
+				t.Errorf("Expected path %s, got %s", projectStatusPath, req.URL.Path)
+			}
+			if req.URL.Query().Get("analysisId") != "AX1" {
+				t.Errorf("Expected analysisId AX1, got %s", req.URL.Query().Get("analysisId"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"projectStatus":{"status":"ERROR","conditions":[{"status":"ERROR","metricKey":"new_bugs"}]}}`)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar/", "token")
+	client.AuthScheme = authSchemeBasic
+	project, err := client.ProjectStatus(context.Background(), url.Values{"analysisId": {"AX1"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if project.ProjectStatus.Status != "ERROR" || len(project.ProjectStatus.Conditions) != 1 {
+		t.Errorf("Unexpected project status %+v", project.ProjectStatus)
+	}
+}
+
+func TestSonarClientNegotiatesAuthOnce(t *testing.T) {
+	validations := 0
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			bearer := req.Header.Get("Authorization") == "Bearer token"
+			if req.URL.Path == authValidatePath {
+				validations++
+				valid := `{"valid":false}`
+				if bearer {
+					valid = `{"valid":true}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(valid)),
+				}
+			}
+			if !bearer {
+				t.Errorf("Expected Bearer auth on %s, got %q", req.URL.Path, req.Header.Get("Authorization"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"status":"PENDING"}}`)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	for i := 0; i < 2; i++ {
+		task, err := client.Task(context.Background(), "AX1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if task.Task.Status != "PENDING" {
+			t.Errorf("Expected PENDING, got %v", task.Task.Status)
+		}
+	}
+	if client.AuthScheme != authSchemeBearer {
+		t.Errorf("Expected Bearer scheme, got %q", client.AuthScheme)
+	}
+	if validations != 2 {
+		t.Errorf("Expected 2 validation requests (Basic then Bearer), got %d", validations)
+	}
+}
+
+func TestSonarClientRejectedToken(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"valid":false}`)),
+			}
+		}),
+	}
+
+	_, err := NewSonarClient("http://sonar", "token").Task(context.Background(), "AX1")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestSonarClientTypedErrors(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrUnauthorized},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusBadGateway, ErrServerError},
+	}
+	for _, tt := range tests {
+		statusCode := tt.statusCode
+		netClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				return &http.Response{
+					StatusCode: statusCode,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"errors":[{"msg":"Component key 'foo' not found"}]}`)),
+				}
+			}),
+		}
+
+		client := NewSonarClient("http://sonar", "token")
+		client.AuthScheme = authSchemeBasic
+		client.Retry = RetryPolicy{}
+		_, err := client.Task(context.Background(), "AX1")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("HTTP %d: expected %v, got %v", statusCode, tt.want, err)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || len(apiErr.Messages) != 1 {
+			t.Errorf("HTTP %d: expected APIError with server message, got %v", statusCode, err)
+		}
+	}
+}
+
+func TestSonarClientRetriesTransientFailures(t *testing.T) {
+	calls := 0
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			calls++
+			if calls < 3 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Header:     http.Header{"Retry-After": {"0"}},
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`maintenance`)),
+				}
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"status":"SUCCESS"}}`)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	client.Retry = RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	task, err := client.Task(context.Background(), "AX1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Task.Status != "SUCCESS" {
+		t.Errorf("Expected SUCCESS, got %v", task.Task.Status)
+	}
+	if client.Retries != 2 {
+		t.Errorf("Expected 2 retries, got %d", client.Retries)
+	}
+}
+
+func TestSonarClientDoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"errors":[{"msg":"bad"}]}`)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	client.Retry = RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	if _, err := client.Task(context.Background(), "AX1"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if calls != 1 || client.Retries != 0 {
+		t.Errorf("Expected a single call without retries, got %d calls and %d retries", calls, client.Retries)
+	}
+}
+
+func TestRetryPolicyCapsRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+	if got := policy.backoff(0, &APIError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}); got != 30*time.Second {
+		t.Errorf("Expected Retry-After capped to 30s, got %v", got)
+	}
+	if got := policy.backoff(0, &APIError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 5 * time.Second}); got != 5*time.Second {
+		t.Errorf("Expected Retry-After 5s, got %v", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("7"); got != 7*time.Second {
+		t.Errorf("Expected 7s, got %v", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got <= 0 || got > time.Minute {
+		t.Errorf("Expected a delay up to 1m, got %v", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("Expected 0, got %v", got)
+	}
+}
+
+func TestSonarClientCancelTask(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			if req.Method != http.MethodPost || req.URL.Path != ceCancelPath || req.URL.Query().Get("id") != "AX1" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusNoContent,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(``)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	if err := client.CancelTask(context.Background(), "AX1"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func TestSonarClientUnreachable(t *testing.T) {
+	netClient = &http.Client{Transport: failingTransport{}}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	client.Retry = RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	_, err := client.Task(context.Background(), "AX1")
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Expected ErrUnreachable, got %v", err)
+	}
+	if client.Retries != 1 {
+		t.Errorf("Expected 1 retry, got %d", client.Retries)
+	}
+}
+
+func TestSonarClientMeasures(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			query := req.URL.Query()
+			if req.URL.Path != measuresComponentPath || query.Get("component") != "project" || query.Get("pullRequest") != "12" {
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			if query.Get("metricKeys") != "coverage,new_bugs" {
+				t.Errorf("Expected metricKeys coverage,new_bugs, got %s", query.Get("metricKeys"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{"component":{"key":"project","measures":[` +
+					`{"metric":"coverage","value":"81.5"},` +
+					`{"metric":"new_bugs","period":{"index":1,"value":"2"}}]}}`)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	measures, err := client.Measures(context.Background(), url.Values{"component": {"project"}, "pullRequest": {"12"}}, []string{"coverage", "new_bugs"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	values := measures.Values()
+	if values["coverage"] != "81.5" || values["new_bugs"] != "2" {
+		t.Errorf("Unexpected measures %v", values)
+	}
+}
+
+func TestLookupGateDefinition(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			body := ""
+			switch req.URL.Path {
+			case gateByProjectPath:
+				if req.URL.Query().Get("project") != "project" {
+					t.Errorf("Unexpected request %s", req.URL)
+				}
+				body = `{"qualityGate":{"id":"AU-Tpxb--iU5OvuD2FLy","name":"Strict way","default":false}}`
+			case gateShowPath:
+				if req.URL.Query().Get("name") != "Strict way" {
+					t.Errorf("Unexpected request %s", req.URL)
+				}
+				body = `{"name":"Strict way","isBuiltIn":false,"conditions":[` +
+					`{"id":"AU-TpxcA-iU5OvuD2FL1","metric":"new_coverage","op":"LT","error":"85"},` +
+					`{"id":"AU-TpxcA-iU5OvuD2FL3","metric":"new_bugs","op":"GT","error":"0"}]}`
+			default:
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	definition := lookupGateDefinition(context.Background(), client, "project", "")
+	if definition == nil || definition.Name != "Strict way" || len(definition.Conditions) != 2 || definition.Conditions[0].Error != "85" {
+		t.Fatalf("Unexpected definition %+v", definition)
+	}
+
+	gate := evaluateGate(testProject("OK", Condition{Status: "OK", MetricKey: "new_coverage", ActualValue: "90"}), nil)
+	gate.Definition = definition
+	if name := ParseJunit(gate, "project").TestSuite[0].Name; !strings.HasPrefix(name, "Quality Gate Strict way") {
+		t.Errorf("Expected the gate name in the testsuite name, got %s", name)
+	}
+}
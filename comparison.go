@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// comparisonMetrics are the measures compared between a pull request and its target branch.
+var comparisonMetrics = []string{"coverage", "bugs", "vulnerabilities", "code_smells", "duplicated_lines_density", "ncloc"}
+
+type (
+	// MeasureComparison holds the key measures of a pull request and of its target branch.
+	MeasureComparison struct {
+		PullRequest string            `json:"pullRequest"`
+		Target      string            `json:"target"` // target branch, "main branch" when not configured
+		Measures    []ComparedMeasure `json:"measures"`
+	}
+
+	// ComparedMeasure is one metric of the comparison. Delta is empty when a
+	// value is missing or not a number.
+	ComparedMeasure struct {
+		Metric      string `json:"metric"`
+		PullRequest string `json:"pullRequest"`
+		Target      string `json:"target"`
+		Delta       string `json:"delta"`
+	}
+)
+
+// comparePullRequest fetches the key measures of the pull request and of the
+// target branch, the project main branch when target is empty.
+func comparePullRequest(ctx context.Context, client *SonarClient, projectKey string, pullRequest string, target string) (*MeasureComparison, error) {
+	prMeasures, err := client.Measures(ctx, url.Values{"component": {projectKey}, "pullRequest": {pullRequest}}, comparisonMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request measures: %w", err)
+	}
+	targetParams := url.Values{"component": {projectKey}}
+	if target != "" {
+		targetParams.Set("branch", target)
+	}
+	targetMeasures, err := client.Measures(ctx, targetParams, comparisonMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target branch measures: %w", err)
+	}
+
+	if target == "" {
+		target = "main branch"
+	}
+	return &MeasureComparison{
+		PullRequest: pullRequest,
+		Target:      target,
+		Measures:    compareMeasures(prMeasures.Values(), targetMeasures.Values()),
+	}, nil
+}
+
+// exportComparison prints the pull request comparison and writes it to the
+// comparison files. The comparison is informative, failures are only logged
+// and nil is returned.
+func (p *Plugin) exportComparison(ctx context.Context, client *SonarClient, projectKey string, pullRequest string) *MeasureComparison {
+	comparison, err := comparePullRequest(ctx, client, projectKey, pullRequest, p.Config.PRBase)
+	if err == nil {
+		displayComparison(comparison)
+		err = writeComparison(comparison, p.Config.ComparisonMarkdownFile, p.Config.ComparisonJSONFile)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Unable to compare the pull request with its target branch")
+		return nil
+	}
+	return comparison
+}
+
+// compareMeasures pairs the comparison metrics of the pull request and of the target.
+func compareMeasures(pullRequest map[string]string, target map[string]string) []ComparedMeasure {
+	measures := []ComparedMeasure{}
+	for _, metric := range comparisonMetrics {
+		measures = append(measures, ComparedMeasure{
+			Metric:      metric,
+			PullRequest: pullRequest[metric],
+			Target:      target[metric],
+			Delta:       measureDelta(pullRequest[metric], target[metric]),
+		})
+	}
+	return measures
+}
+
+// measureDelta returns value-base rounded to two decimals, signed, such as
+// "+1.5" or "-3". It is empty when one side is not a number.
+func measureDelta(value string, base string) string {
+	current, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return ""
+	}
+	previous, err := strconv.ParseFloat(base, 64)
+	if err != nil {
+		return ""
+	}
+	delta := math.Round((current-previous)*100) / 100
+	if delta > 0 {
+		return "+" + strconv.FormatFloat(delta, 'f', -1, 64)
+	}
+	if delta == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(delta, 'f', -1, 64)
+}
+
+// displayComparison prints the pull request comparison table.
+func displayComparison(comparison *MeasureComparison) {
+	fmt.Println(lineBreak2)
+	fmt.Printf("| %-62s |\n", "PULL REQUEST "+comparison.PullRequest+" VS "+comparison.Target)
+	fmt.Println(lineBreak2)
+	fmt.Printf("| %-26s | %-10s | %-10s | %-8s |\n", "METRIC", "PR", "TARGET", "DELTA")
+	fmt.Println(lineBreak2)
+	for _, measure := range comparison.Measures {
+		fmt.Printf("| %-26s | %-10s | %-10s | %-8s |\n", measure.Metric, valueOrDash(measure.PullRequest), valueOrDash(measure.Target), valueOrDash(measure.Delta))
+	}
+	fmt.Println(lineBreak2)
+	fmt.Println("")
+}
+
+// Markdown returns the comparison as a markdown table.
+func (c *MeasureComparison) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "| Metric | Pull request %s | %s | Delta |\n", c.PullRequest, c.Target)
+	b.WriteString("|--------|------|------|-------|\n")
+	for _, measure := range c.Measures {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", measure.Metric, valueOrDash(measure.PullRequest), valueOrDash(measure.Target), valueOrDash(measure.Delta))
+	}
+	return b.String()
+}
+
+// writeComparison writes the comparison as markdown to markdownFile and as
+// JSON to jsonFile. An empty path skips that file.
+func writeComparison(comparison *MeasureComparison, markdownFile string, jsonFile string) error {
+	if markdownFile != "" {
+		markdown := "## SonarQube measures: pull request " + comparison.PullRequest + " vs " + comparison.Target + "\n\n" + comparison.Markdown()
+		if err := os.WriteFile(markdownFile, []byte(markdown), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", markdownFile, err)
+		}
+	}
+	if jsonFile != "" {
+		data, _ := json.MarshalIndent(comparison, "", "  ")
+		if err := os.WriteFile(jsonFile, data, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", jsonFile, err)
+		}
+	}
+	return nil
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMeasureDelta(t *testing.T) {
+	tests := map[[2]string]string{
+		{"81.3", "80.1"}: "+1.2",
+		{"3", "5"}:       "-2",
+		{"10", "10.0"}:   "0",
+		{"", "10"}:       "",
+		{"1", "A"}:       "",
+	}
+	for values, expected := range tests {
+		if delta := measureDelta(values[0], values[1]); delta != expected {
+			t.Errorf("measureDelta(%q, %q): expected %q, got %q", values[0], values[1], expected, delta)
+		}
+	}
+}
+
+func TestComparePullRequest(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			query := req.URL.Query()
+			body := `{"component":{"measures":[{"metric":"coverage","value":"70.0"},{"metric":"bugs","value":"4"}]}}`
+			if query.Get("pullRequest") == "42" {
+				body = `{"component":{"measures":[{"metric":"coverage","value":"72.5"},{"metric":"bugs","value":"3"}]}}`
+			} else if query.Get("branch") != "develop" {
+				t.Errorf("Expected the target branch develop, got %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	comparison, err := comparePullRequest(context.Background(), client, "project", "42", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(comparison.Measures) != len(comparisonMetrics) {
+		t.Fatalf("Expected %d measures, got %+v", len(comparisonMetrics), comparison.Measures)
+	}
+	if coverage := comparison.Measures[0]; coverage.PullRequest != "72.5" || coverage.Target != "70.0" || coverage.Delta != "+2.5" {
+		t.Errorf("Unexpected coverage comparison %+v", coverage)
+	}
+	if bugs := comparison.Measures[1]; bugs.Delta != "-1" {
+		t.Errorf("Unexpected bugs comparison %+v", bugs)
+	}
+	if !strings.Contains(comparison.Markdown(), "| ncloc | - | - | - |") {
+		t.Errorf("Expected missing measures as dashes, got\n%s", comparison.Markdown())
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	comparison := &MeasureComparison{
+		PullRequest: "42",
+		Target:      "main branch",
+		Measures:    compareMeasures(map[string]string{"bugs": "3"}, map[string]string{"bugs": "4"}),
+	}
+	dir := t.TempDir()
+	markdownFile := filepath.Join(dir, "comparison.md")
+	if err := writeComparison(comparison, markdownFile, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	markdown, err := os.ReadFile(markdownFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(markdown), "| bugs | 3 | 4 | -1 |") {
+		t.Errorf("Unexpected markdown\n%s", markdown)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the markdown file, got %v", files)
+	}
+
+	jsonFile := filepath.Join(dir, "comparison.json")
+	if err := writeComparison(comparison, "", jsonFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written MeasureComparison
+	if err := json.Unmarshal(data, &written); err != nil || written.PullRequest != "42" {
+		t.Errorf("Unexpected JSON %s: %v", data, err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Condition policies of gate_condition_policy
+const (
+	policyFail   = "fail"
+	policyWarn   = "warn"
+	policyIgnore = "ignore"
+)
+
+// Condition verdicts, on top of the server statuses OK, WARN and ERROR
+const (
+	verdictOK      = "OK"
+	verdictWarn    = "WARN"
+	verdictError   = "ERROR"
+	verdictIgnored = "IGNORED"
+)
+
+// statusNone is the gate status of a project without a quality gate.
+const statusNone = "NONE"
+
+type (
+	// GateResult is the quality gate as evaluated by the plugin, after the
+	// plugin-side policies were applied to the server conditions.
+	GateResult struct {
+		ServerStatus string                 // status computed by the server
+		Status       string                 // effective status used to pass or fail the step
+		Conditions   []GateCondition        // server conditions with their plugin verdict
+		Thresholds   []ThresholdResult      // local metric thresholds, from metric_thresholds
+		Ratchet      []RatchetResult        // ratchet versus the previous analysis
+		Period       *Period                // new code period of the new_* conditions
+		Definition   *QualityGateDefinition // gate assigned to the project, nil when unknown
+	}
+
+	// GateCondition is a server condition with the policy applied to it.
+	GateCondition struct {
+		Condition
+		Policy  string // fail, warn or ignore
+		Verdict string // OK, WARN, ERROR or IGNORED
+	}
+)
+
+// Overridden reports whether the plugin policy changed the outcome of the condition.
+func (c GateCondition) Overridden() bool {
+	return c.Policy != policyFail && c.Status != verdictOK
+}
+
+// Name returns the name of the quality gate, empty when unknown.
+func (g GateResult) Name() string {
+	if g.Definition == nil {
+		return ""
+	}
+	return g.Definition.Name
+}
+
+// Overridden returns the conditions whose outcome was changed by a policy.
+func (g GateResult) Overridden() []GateCondition {
+	overridden := []GateCondition{}
+	for _, condition := range g.Conditions {
+		if condition.Overridden() {
+			overridden = append(overridden, condition)
+		}
+	}
+	return overridden
+}
+
+// FailedThresholds returns the local metric thresholds that were not met.
+func (g GateResult) FailedThresholds() []ThresholdResult {
+	failed := []ThresholdResult{}
+	for _, threshold := range g.Thresholds {
+		if !threshold.Passed {
+			failed = append(failed, threshold)
+		}
+	}
+	return failed
+}
+
+// applyThresholds combines the local metric thresholds with the gate, a failed
+// threshold fails the gate whatever the server said.
+func (g *GateResult) applyThresholds(results []ThresholdResult) {
+	g.Thresholds = results
+	if len(g.FailedThresholds()) > 0 {
+		g.Status = verdictError
+	}
+}
+
+// Regressions returns the ratchet metrics that got worse than the previous analysis.
+func (g GateResult) Regressions() []RatchetResult {
+	regressions := []RatchetResult{}
+	for _, result := range g.Ratchet {
+		if !result.Passed {
+			regressions = append(regressions, result)
+		}
+	}
+	return regressions
+}
+
+// applyRatchet combines the ratchet with the gate, a regression fails the gate.
+func (g *GateResult) applyRatchet(results []RatchetResult) {
+	g.Ratchet = results
+	if len(g.Regressions()) > 0 {
+		g.Status = verdictError
+	}
+}
+
+// parseConditionPolicies reads gate_condition_policy, a comma separated list
+// of metric=policy pairs such as "new_duplicated_lines_density=warn".
+func parseConditionPolicies(value string) (map[string]string, error) {
+	policies := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("gate_condition_policy entry %q must look like metric=fail|warn|ignore: %w", entry, ErrConfig)
+		}
+		metric := strings.TrimSpace(parts[0])
+		policy := strings.ToLower(strings.TrimSpace(parts[1]))
+		switch policy {
+		case policyFail, policyWarn, policyIgnore:
+			policies[metric] = policy
+		default:
+			return nil, fmt.Errorf("gate_condition_policy %q has unknown policy %q, use fail, warn or ignore: %w", metric, policy, ErrConfig)
+		}
+	}
+	return policies, nil
+}
+
+// newCodeOnlyPolicies returns the policies of the new code only mode: failed
+// conditions on overall code (metrics not starting with new_) are reported as
+// warnings. Policies set explicitly in gate_condition_policy are kept.
+func newCodeOnlyPolicies(project *Project, policies map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, condition := range project.ProjectStatus.Conditions {
+		if !strings.HasPrefix(condition.MetricKey, "new_") {
+			result[condition.MetricKey] = policyWarn
+		}
+	}
+	for metric, policy := range policies {
+		result[metric] = policy
+	}
+	return result
+}
+
+// evaluateGate applies the condition policies to the server quality gate and
+// recomputes the effective status. Without overrides the server status is kept.
+func evaluateGate(project *Project, policies map[string]string) GateResult {
+	gate := GateResult{
+		ServerStatus: project.ProjectStatus.Status,
+		Status:       project.ProjectStatus.Status,
+		Period:       project.ProjectStatus.NewCodePeriod(),
+	}
+
+	overridden := false
+	for _, condition := range project.ProjectStatus.Conditions {
+		evaluated := GateCondition{Condition: condition, Policy: policyFail, Verdict: condition.Status}
+		if policy, ok := policies[condition.MetricKey]; ok {
+			evaluated.Policy = policy
+		}
+		if evaluated.Overridden() {
+			overridden = true
+			if evaluated.Policy == policyIgnore {
+				evaluated.Verdict = verdictIgnored
+			} else {
+				evaluated.Verdict = verdictWarn
+			}
+		}
+		gate.Conditions = append(gate.Conditions, evaluated)
+	}
+
+	if overridden {
+		gate.Status = verdictOK
+		for _, condition := range gate.Conditions {
+			if condition.Policy != policyFail {
+				continue
+			}
+			if condition.Verdict == verdictError {
+				gate.Status = verdictError
+				break
+			}
+			if condition.Verdict == verdictWarn {
+				gate.Status = verdictWarn
+			}
+		}
+	}
+	return gate
+}
+
+// parseAcceptedStatuses reads quality, the comma separated gate statuses that
+// pass the step, such as "OK,WARN". An empty value accepts OK only.
+func parseAcceptedStatuses(quality string) ([]string, error) {
+	accepted := []string{}
+	for _, status := range strings.Split(quality, ",") {
+		status = strings.ToUpper(strings.TrimSpace(status))
+		switch status {
+		case "":
+			continue
+		case verdictOK, verdictWarn, verdictError, statusNone:
+			accepted = append(accepted, status)
+		default:
+			return nil, fmt.Errorf("quality %q has unknown status %q, use OK, WARN, ERROR or NONE: %w", quality, status, ErrConfig)
+		}
+	}
+	if len(accepted) == 0 {
+		accepted = append(accepted, verdictOK)
+	}
+	return accepted, nil
+}
+
+// explainGateStatus reports whether status is one of the accepted statuses and
+// explains the decision for the console.
+func explainGateStatus(status string, accepted []string) (bool, string) {
+	passed := false
+	for _, candidate := range accepted {
+		if status == candidate {
+			passed = true
+			break
+		}
+	}
+	list := strings.Join(accepted, ",")
+
+	switch {
+	case passed && status == statusNone:
+		return true, "no quality gate is assigned to the project, NONE is accepted by quality=" + list
+	case passed:
+		return true, "status " + status + " is accepted by quality=" + list
+	case status == statusNone:
+		return false, "no quality gate is assigned to the project on the server, assign one or add NONE to quality=" + list + " to accept it"
+	case status == verdictWarn:
+		return false, "status WARN means conditions went over their warning threshold (servers before 7.6), add WARN to quality=" + list + " to accept it"
+	case status == verdictError:
+		return false, "status ERROR means at least one condition failed, quality=" + list + " does not accept it"
+	case status == "":
+		return false, "the quality gate status is unknown, quality=" + list
+	}
+	return false, "status " + status + " is not accepted by quality=" + list
+}
+
+// displayGateDefinition prints every condition defined in the quality gate
+// next to its evaluated value. Conditions the server did not evaluate, such as
+// new code conditions on a first analysis, are shown as not evaluated.
+func displayGateDefinition(gate GateResult) {
+	if gate.Definition == nil {
+		return
+	}
+	evaluated := map[string]GateCondition{}
+	for _, condition := range gate.Conditions {
+		evaluated[condition.MetricKey] = condition
+	}
+
+	kind := ""
+	if gate.Definition.IsBuiltIn {
+		kind = " (built-in)"
+	}
+	fmt.Println(lineBreak2)
+	fmt.Printf("| %-62s |\n", "QUALITY GATE: "+gate.Definition.Name+kind)
+	fmt.Println(lineBreak2)
+	for _, defined := range gate.Definition.Conditions {
+		actual, verdict := "-", "NOT EVALUATED"
+		if condition, ok := evaluated[defined.Metric]; ok {
+			actual, verdict = condition.ActualValue, condition.Verdict
+		}
+		fmt.Printf("| %-30s | %-2s %-8s | %-8s | %-7s |\n", defined.Metric, defined.Op, defined.Error, actual, verdict)
+	}
+	fmt.Println(lineBreak2)
+	fmt.Println("")
+}
+
+// displayGateOverrides prints the conditions changed by gate_condition_policy.
+func displayGateOverrides(gate GateResult) {
+	overridden := gate.Overridden()
+	if len(overridden) == 0 {
+		return
+	}
+	sort.Slice(overridden, func(i, j int) bool { return overridden[i].MetricKey < overridden[j].MetricKey })
+
+	fmt.Println(lineBreak2)
+	fmt.Println("|  CONDITIONS OVERRIDDEN BY PLUGIN POLICY                          |")
+	fmt.Println(lineBreak2)
+	for _, condition := range overridden {
+		fmt.Printf("| %-34s | %-6s -> %-7s | %-9s |\n", condition.MetricKey, condition.Status, condition.Verdict, condition.Policy)
+	}
+	fmt.Println(lineBreak2)
+	fmt.Printf("Server quality gate status: %s, effective status: %s\n\n", gate.ServerStatus, gate.Status)
+}
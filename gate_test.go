@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testProject(status string, conditions ...Condition) *Project {
+	return &Project{ProjectStatus: Status{Status: status, Conditions: conditions}}
+}
+
+func TestParseConditionPolicies(t *testing.T) {
+	policies, err := parseConditionPolicies(" new_duplicated_lines_density=warn, new_security_hotspots_reviewed=IGNORE ,,coverage=fail")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"new_duplicated_lines_density":   policyWarn,
+		"new_security_hotspots_reviewed": policyIgnore,
+		"coverage":                       policyFail,
+	}
+	if len(policies) != len(expected) {
+		t.Fatalf("Expected %d policies, got %v", len(expected), policies)
+	}
+	for metric, policy := range expected {
+		if policies[metric] != policy {
+			t.Errorf("Expected %s for %s, got %q", policy, metric, policies[metric])
+		}
+	}
+
+	for _, value := range []string{"coverage", "=warn", "coverage=skip"} {
+		if _, err := parseConditionPolicies(value); !errors.Is(err, ErrConfig) {
+			t.Errorf("Expected ErrConfig for %q, got %v", value, err)
+		}
+	}
+}
+
+func TestEvaluateGate(t *testing.T) {
+	project := testProject("ERROR",
+		Condition{Status: "ERROR", MetricKey: "new_duplicated_lines_density"},
+		Condition{Status: "ERROR", MetricKey: "new_security_hotspots_reviewed"},
+		Condition{Status: "OK", MetricKey: "new_coverage"},
+	)
+
+	gate := evaluateGate(project, map[string]string{})
+	if gate.Status != "ERROR" || len(gate.Overridden()) != 0 {
+		t.Errorf("Expected the server verdict without policies, got %+v", gate)
+	}
+
+	gate = evaluateGate(project, map[string]string{"new_duplicated_lines_density": policyWarn})
+	if gate.Status != "ERROR" {
+		t.Errorf("Expected ERROR while a failing condition remains, got %s", gate.Status)
+	}
+
+	gate = evaluateGate(project, map[string]string{
+		"new_duplicated_lines_density":   policyWarn,
+		"new_security_hotspots_reviewed": policyIgnore,
+		"new_coverage":                   policyIgnore,
+	})
+	if gate.ServerStatus != "ERROR" || gate.Status != "OK" {
+		t.Errorf("Expected ERROR overridden to OK, got %s -> %s", gate.ServerStatus, gate.Status)
+	}
+	if overridden := gate.Overridden(); len(overridden) != 2 {
+		t.Errorf("Expected 2 overridden conditions, got %+v", overridden)
+	}
+	verdicts := map[string]string{}
+	for _, condition := range gate.Conditions {
+		verdicts[condition.MetricKey] = condition.Verdict
+	}
+	if verdicts["new_duplicated_lines_density"] != verdictWarn || verdicts["new_security_hotspots_reviewed"] != verdictIgnored || verdicts["new_coverage"] != verdictOK {
+		t.Errorf("Unexpected verdicts %v", verdicts)
+	}
+}
+
+func TestParseJunitGatePolicies(t *testing.T) {
+	gate := evaluateGate(testProject("ERROR",
+		Condition{Status: "ERROR", MetricKey: "new_bugs", Comparator: "GT", ErrorThreshold: "0", ActualValue: "2"},
+		Condition{Status: "ERROR", MetricKey: "new_duplicated_lines_density", Comparator: "GT", ErrorThreshold: "3", ActualValue: "5"},
+		Condition{Status: "ERROR", MetricKey: "new_security_hotspots_reviewed", Comparator: "LT", ErrorThreshold: "100", ActualValue: "0"},
+	), map[string]string{
+		"new_duplicated_lines_density":   policyWarn,
+		"new_security_hotspots_reviewed": policyIgnore,
+	})
+
+	report := ParseJunit(gate, "project")
+	cases := map[string]Testcase{}
+	for _, testCase := range report.TestSuite[0].TestCase {
+		cases[testCase.Name] = testCase
+	}
+	if cases["new_bugs"].Failure == nil {
+		t.Error("Expected new_bugs to fail")
+	}
+	if warned := cases["new_duplicated_lines_density"]; warned.Failure != nil || warned.SystemOut == "" {
+		t.Errorf("Expected a passing testcase with a warning note, got %+v", warned)
+	}
+	if ignored := cases["new_security_hotspots_reviewed"]; ignored.Failure != nil || ignored.Skipped == nil {
+		t.Errorf("Expected a skipped testcase, got %+v", ignored)
+	}
+
+	total, failed, newErrors := summarizeJunit(report)
+	if total != 3 || failed != 1 || newErrors != 1 {
+		t.Errorf("Expected 3 total, 1 failed, 1 new error, got %d, %d, %d", total, failed, newErrors)
+	}
+}
+
+func TestParseAcceptedStatuses(t *testing.T) {
+	accepted, err := parseAcceptedStatuses(" ok, warn ,NONE")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(accepted) != 3 || accepted[0] != "OK" || accepted[1] != "WARN" || accepted[2] != "NONE" {
+		t.Errorf("Unexpected statuses %v", accepted)
+	}
+	if accepted, _ := parseAcceptedStatuses(""); len(accepted) != 1 || accepted[0] != "OK" {
+		t.Errorf("Expected OK by default, got %v", accepted)
+	}
+	if _, err := parseAcceptedStatuses("OK,PASSED"); !errors.Is(err, ErrConfig) {
+		t.Errorf("Expected ErrConfig, got %v", err)
+	}
+}
+
+func TestExplainGateStatus(t *testing.T) {
+	tests := []struct {
+		status   string
+		accepted []string
+		passed   bool
+	}{
+		{"OK", []string{"OK"}, true},
+		{"WARN", []string{"OK"}, false},
+		{"WARN", []string{"OK", "WARN"}, true},
+		{"NONE", []string{"OK"}, false},
+		{"NONE", []string{"OK", "NONE"}, true},
+		{"ERROR", []string{"OK", "WARN"}, false},
+	}
+	for _, test := range tests {
+		passed, reason := explainGateStatus(test.status, test.accepted)
+		if passed != test.passed || reason == "" {
+			t.Errorf("%s with %v: expected passed=%v, got %v (%s)", test.status, test.accepted, test.passed, passed, reason)
+		}
+	}
+	if _, reason := explainGateStatus("NONE", []string{"OK"}); !strings.Contains(reason, "no quality gate") {
+		t.Errorf("Expected NONE to explain the missing gate, got %s", reason)
+	}
+}
+
+func TestNewCodeOnlyPolicies(t *testing.T) {
+	project := testProject("ERROR",
+		Condition{Status: "ERROR", MetricKey: "coverage"},
+		Condition{Status: "ERROR", MetricKey: "duplicated_lines_density"},
+		Condition{Status: "OK", MetricKey: "new_coverage"},
+	)
+	gate := evaluateGate(project, newCodeOnlyPolicies(project, map[string]string{"duplicated_lines_density": policyIgnore}))
+	if gate.Status != "OK" {
+		t.Errorf("Expected overall code failures not to fail the gate, got %s", gate.Status)
+	}
+	verdicts := map[string]string{}
+	for _, condition := range gate.Conditions {
+		verdicts[condition.MetricKey] = condition.Verdict
+	}
+	if verdicts["coverage"] != verdictWarn || verdicts["duplicated_lines_density"] != verdictIgnored {
+		t.Errorf("Unexpected verdicts %v", verdicts)
+	}
+
+	project.ProjectStatus.Conditions[2].Status = "ERROR"
+	if gate := evaluateGate(project, newCodeOnlyPolicies(project, nil)); gate.Status != "ERROR" {
+		t.Errorf("Expected a failed new code condition to fail the gate, got %s", gate.Status)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// htmlStyle is inlined in the HTML report so it opens without network access.
+const htmlStyle = `body{font-family:-apple-system,"Segoe UI",Helvetica,Arial,sans-serif;margin:0 auto;max-width:1100px;padding:24px;color:#24292f}
+h1{font-size:24px}h2{font-size:18px;border-bottom:1px solid #d0d7de;padding-bottom:4px;margin-top:32px}h3{font-size:15px}h4{font-size:13px;font-family:monospace;margin:12px 0 4px}
+table{border-collapse:collapse;width:100%;margin-bottom:12px;font-size:13px}th,td{border:1px solid #d0d7de;padding:4px 8px;text-align:left}th{background:#f6f8fa}
+a{color:#0969da}.status{display:inline-block;padding:2px 10px;border-radius:4px;color:#fff;font-weight:bold}
+.OK{background:#2da44e}.WARN{background:#d4a72c}.ERROR{background:#cf222e}.NONE,.IGNORED{background:#8c959f}
+.muted{color:#57606a}`
+
+// HTML renders the report as a single page with inline CSS, for readers
+// without access to the server.
+func (a *AnalysisReport) HTML() string {
+	var b strings.Builder
+	esc := html.EscapeString
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>SonarQube report - %s</title>\n<style>\n%s\n</style>\n</head>\n<body>\n", esc(a.ProjectKey), htmlStyle)
+
+	fmt.Fprintf(&b, "<h1>SonarQube quality gate <span class=\"status %s\">%s</span></h1>\n", esc(a.Gate.Status), esc(a.Gate.Status))
+	fmt.Fprintf(&b, "<p><strong>Project:</strong> <a href=\"%s\">%s</a> (%s)", esc(a.DashboardURL), esc(a.ProjectKey), esc(a.Scope()))
+	if name := a.Gate.Name(); name != "" {
+		fmt.Fprintf(&b, " &middot; <strong>Quality gate:</strong> %s", esc(name))
+	}
+	b.WriteString("</p>\n")
+	fmt.Fprintf(&b, "<p>%s</p>\n", esc(a.Reason))
+	if a.Gate.ServerStatus != a.Gate.Status {
+		fmt.Fprintf(&b, "<p class=\"muted\">The server status is %s, the plugin settings changed it to %s.</p>\n", esc(a.Gate.ServerStatus), esc(a.Gate.Status))
+	}
+	if a.Gate.Period != nil {
+		fmt.Fprintf(&b, "<p class=\"muted\">New code: %s (since %s).</p>\n", esc(a.Gate.Period.Description()), esc(valueOrDash(a.Gate.Period.Date)))
+	}
+
+	if len(a.Gate.Conditions) > 0 {
+		b.WriteString("<h2>Conditions</h2>\n<table>\n<tr><th>Metric</th><th>Actual</th><th>Condition</th><th>Policy</th><th>Status</th></tr>\n")
+		for _, condition := range a.Gate.Conditions {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s %s</td><td>%s</td><td><span class=\"status %s\">%s</span></td></tr>\n",
+				esc(condition.MetricKey), esc(valueOrDash(condition.ActualValue)), esc(condition.Comparator), esc(condition.ErrorThreshold),
+				esc(condition.Policy), esc(condition.Verdict), esc(condition.Verdict))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	if len(a.Gate.Thresholds) > 0 || len(a.Gate.Ratchet) > 0 {
+		b.WriteString("<h2>Plugin checks</h2>\n<table>\n<tr><th>Check</th><th>Result</th><th>Status</th></tr>\n")
+		for _, threshold := range a.Gate.Thresholds {
+			b.WriteString(htmlCheckRow(threshold.Threshold.String(), threshold.Message, threshold.Passed))
+		}
+		for _, result := range a.Gate.Ratchet {
+			b.WriteString(htmlCheckRow("ratchet "+result.Metric, result.Message, result.Passed))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	if len(a.Measures) > 0 {
+		b.WriteString("<h2>Measures</h2>\n<table>\n<tr><th>Metric</th><th>Value</th></tr>\n")
+		for _, metric := range keyMetrics {
+			if value, ok := a.Measures[metric]; ok {
+				fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td></tr>\n", esc(metric), esc(value))
+			}
+		}
+		b.WriteString("</table>\n")
+	}
+
+	if a.Comparison != nil {
+		fmt.Fprintf(&b, "<h2>Compared to %s</h2>\n<table>\n<tr><th>Metric</th><th>Pull request</th><th>%s</th><th>Delta</th></tr>\n", esc(a.Comparison.Target), esc(a.Comparison.Target))
+		for _, measure := range a.Comparison.Measures {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				esc(measure.Metric), esc(valueOrDash(measure.PullRequest)), esc(valueOrDash(measure.Target)), esc(valueOrDash(measure.Delta)))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	if a.Issues != nil {
+		fmt.Fprintf(&b, "<h2>Open issues (%d)</h2>\n", a.Issues.Total)
+		if len(a.Issues.Issues) < a.Issues.Total {
+			fmt.Fprintf(&b, "<p class=\"muted\">Only the first %d issues are listed.</p>\n", len(a.Issues.Issues))
+		}
+		if len(a.Issues.Issues) == 0 {
+			b.WriteString("<p>No open issues.</p>\n")
+		}
+		a.writeHTMLIssues(&b, sortIssues(a.Issues.Issues))
+	}
+
+	fmt.Fprintf(&b, "<p><a href=\"%s\">Dashboard</a> &middot; <a href=\"%s\">Issues</a></p>\n", esc(a.DashboardURL), esc(a.IssuesURL()))
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+// writeHTMLIssues writes sorted issues as one section per severity and one
+// table per file.
+func (a *AnalysisReport) writeHTMLIssues(b *strings.Builder, issues []Issue) {
+	esc := html.EscapeString
+	severity, file := "", ""
+	for i, issue := range issues {
+		if i == 0 || issue.Severity != severity {
+			if i > 0 {
+				b.WriteString("</table>\n")
+			}
+			severity, file = issue.Severity, ""
+			count := 0
+			for _, other := range issues {
+				if other.Severity == severity {
+					count++
+				}
+			}
+			fmt.Fprintf(b, "<h3>%s (%d)</h3>\n", esc(valueOrDash(severity)), count)
+		}
+		if file == "" || valueOrDash(issue.File) != file {
+			if file != "" {
+				b.WriteString("</table>\n")
+			}
+			file = valueOrDash(issue.File)
+			fmt.Fprintf(b, "<h4>%s</h4>\n<table>\n<tr><th>Line</th><th>Type</th><th>Issue</th><th>Rule</th></tr>\n", esc(file))
+		}
+		line := "-"
+		if issue.Line > 0 {
+			line = fmt.Sprintf("%d", issue.Line)
+		}
+		fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td><td><a href=\"%s\">%s</a></td><td title=\"%s\">%s</td></tr>\n",
+			line, esc(issue.Type), esc(a.IssueURL(issue)), esc(issue.Message), esc(issue.Rule), esc(a.Issues.RuleName(issue.Rule)))
+	}
+	if len(issues) > 0 {
+		b.WriteString("</table>\n")
+	}
+}
+
+// htmlCheckRow renders a threshold or ratchet result.
+func htmlCheckRow(check string, message string, passed bool) string {
+	status := verdictOK
+	if !passed {
+		status = verdictError
+	}
+	return fmt.Sprintf("<tr><td>%s</td><td>%s</td><td><span class=\"status %s\">%s</span></td></tr>\n",
+		html.EscapeString(check), html.EscapeString(message), status, status)
+}
+
+// exportHTMLReport completes the report with the measures and open issues
+// and writes the HTML report to path.
+func exportHTMLReport(ctx context.Context, client *SonarClient, path string, analysis *AnalysisReport, issueSource *issueSearch, component url.Values) error {
+	if analysis.Measures == nil {
+		if err := analysis.fetchMeasures(ctx, client, component); err != nil {
+			return err
+		}
+	}
+	issues, err := issueSource.get(ctx, false)
+	if err != nil {
+		return err
+	}
+	analysis.Issues = issues
+	return writeHTMLReport(path, analysis)
+}
+
+// writeHTMLReport writes the HTML report to path.
+func writeHTMLReport(path string, analysis *AnalysisReport) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating report directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(analysis.HTML()), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Printf("==> HTML report written to %s\n\n", path)
+	return nil
+}
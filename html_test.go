@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalysisReportHTML(t *testing.T) {
+	analysis := testAnalysisReport()
+	analysis.Issues = &IssuesResponse{Total: 3, Issues: []Issue{
+		{Key: "I1", Rule: "go:S100", Severity: "MINOR", Type: "CODE_SMELL", File: "a.go", Line: 3, Message: "Rename <this>"},
+		{Key: "I2", Rule: "go:S200", Severity: "BLOCKER", Type: "BUG", File: "b.go", Line: 7, Message: "Fix this"},
+		{Key: "I3", Rule: "go:S100", Severity: "MINOR", Type: "CODE_SMELL", File: "a.go", Line: 9, Message: "Rename that"},
+	}, Rules: []IssueRule{{Key: "go:S100", Name: "Names should comply"}}}
+
+	page := analysis.HTML()
+	expected := []string{
+		"<!DOCTYPE html>",
+		"<style>",
+		`SonarQube quality gate <span class="status ERROR">ERROR</span>`,
+		`<a href="http://sonar/dashboard?id=project&amp;pullRequest=42">project</a> (pull request 42)`,
+		"<td>new_coverage</td><td>62.5</td><td>LT 80</td><td>fail</td>",
+		"<tr><td>coverage</td><td>71.0</td></tr>",
+		"<h2>Open issues (3)</h2>",
+		"<h3>BLOCKER (1)</h3>\n<h4>b.go</h4>",
+		"<h3>MINOR (2)</h3>\n<h4>a.go</h4>",
+		"Rename &lt;this&gt;",
+		`<td title="go:S100">Names should comply</td>`,
+	}
+	for _, text := range expected {
+		if !strings.Contains(page, text) {
+			t.Errorf("Expected %q in\n%s", text, page)
+		}
+	}
+	if strings.Count(page, "<h4>a.go</h4>") != 1 {
+		t.Error("Expected the issues of a file in one table")
+	}
+	for _, external := range []string{"<link", "<script", "src="} {
+		if strings.Contains(page, external) {
+			t.Errorf("Expected no external assets, found %q", external)
+		}
+	}
+}
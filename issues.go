@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxIssueResults is the most issues api/issues/search can return for one query.
+const maxIssueResults = 10000
+
+type (
+	// IssuesResponse Search for issues
+	IssuesResponse struct {
+		Total      int         `json:"total"`
+		Paging     Paging      `json:"paging"`
+		Issues     []Issue     `json:"issues"`
+		Components []Component `json:"components"`
+		Rules      []IssueRule `json:"rules"`
+	}
+
+	// Issue is an open issue of the analysed code.
+	Issue struct {
+		Key          string     `json:"key"`
+		Rule         string     `json:"rule"`
+		Severity     string     `json:"severity"` // BLOCKER, CRITICAL, MAJOR, MINOR or INFO
+		Type         string     `json:"type"`     // BUG, VULNERABILITY or CODE_SMELL
+		Component    string     `json:"component"`
+		Line         int        `json:"line"`
+		TextRange    *TextRange `json:"textRange,omitempty"`
+		Message      string     `json:"message"`
+		Status       string     `json:"status"`
+		CreationDate string     `json:"creationDate"`
+		// File is the path of the component in the project, empty for issues on the project itself.
+		File string `json:"-"`
+	}
+
+	// TextRange locates an issue in its file, lines are 1-based and offsets 0-based.
+	TextRange struct {
+		StartLine   int `json:"startLine"`
+		EndLine     int `json:"endLine"`
+		StartOffset int `json:"startOffset"`
+		EndOffset   int `json:"endOffset"`
+	}
+
+	// Component is a file, directory or project referenced by issues.
+	Component struct {
+		Key       string `json:"key"`
+		Path      string `json:"path"`
+		Qualifier string `json:"qualifier"`
+	}
+
+	// IssueRule is a rule referenced by issues.
+	IssueRule struct {
+		Key      string `json:"key"`
+		Name     string `json:"name"`
+		LangName string `json:"langName"`
+	}
+)
+
+// issueSearch fetches the issues of the analysed component once per scope,
+// for the reports that need them.
+type issueSearch struct {
+	client    *SonarClient
+	component url.Values
+	scopes    map[bool]*IssuesResponse
+}
+
+func newIssueSearch(client *SonarClient, component url.Values) *issueSearch {
+	return &issueSearch{client: client, component: component, scopes: map[bool]*IssuesResponse{}}
+}
+
+// get returns the open issues, only those of the new code period when newCodeOnly is set.
+func (s *issueSearch) get(ctx context.Context, newCodeOnly bool) (*IssuesResponse, error) {
+	if issues, ok := s.scopes[newCodeOnly]; ok {
+		return issues, nil
+	}
+	issues, err := searchIssues(ctx, s.client, s.component, newCodeOnly)
+	if err != nil {
+		return nil, err
+	}
+	s.scopes[newCodeOnly] = issues
+	return issues, nil
+}
+
+// searchIssues returns every open issue of the component selected by params,
+// which holds component and optionally branch or pullRequest, reading every
+// page. The rules referenced by the issues are returned too.
+func searchIssues(ctx context.Context, client *SonarClient, component url.Values, newCodeOnly bool) (*IssuesResponse, error) {
+	params := url.Values{
+		"componentKeys":    {component.Get("component")},
+		"resolved":         {"false"},
+		"additionalFields": {"rules"},
+	}
+	for _, key := range []string{"branch", "pullRequest"} {
+		if value := component.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	// Every issue of a pull request is new code
+	if newCodeOnly && component.Get("pullRequest") == "" {
+		params.Set("inNewCodePeriod", "true")
+	}
+
+	fmt.Printf("==> Issues request:\n")
+	fmt.Println(client.BaseURL + issuesSearchPath + "?" + params.Encode())
+	fmt.Printf("\n")
+
+	issues, err := client.SearchIssues(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search issues: %w", err)
+	}
+	if issues.Total > len(issues.Issues) {
+		logrus.WithFields(logrus.Fields{
+			"total":    issues.Total,
+			"exported": len(issues.Issues),
+		}).Warn("SonarQube returns at most 10000 issues, the export is incomplete")
+	}
+
+	paths := map[string]string{}
+	for _, component := range issues.Components {
+		paths[component.Key] = component.Path
+	}
+	for i := range issues.Issues {
+		issue := &issues.Issues[i]
+		issue.File = paths[issue.Component]
+		if issue.File == "" && strings.Contains(issue.Component, ":") && issue.Component != component.Get("component") {
+			issue.File = issue.Component[strings.Index(issue.Component, ":")+1:]
+		}
+	}
+	return issues, nil
+}
+
+// issuesJunit returns the issues as JUnit, one testsuite per file and one
+// failing testcase per issue. Issues on the project itself are grouped under
+// the project key.
+func issuesJunit(issues *IssuesResponse, projectName string) Testsuites {
+	report := Testsuites{TestSuite: []Testsuite{}}
+	suites := map[string]int{}
+	for _, issue := range issues.Issues {
+		file := issue.File
+		if file == "" {
+			file = projectName
+		}
+		index, ok := suites[file]
+		if !ok {
+			index = len(report.TestSuite)
+			suites[file] = index
+			report.TestSuite = append(report.TestSuite, Testsuite{Package: projectName, Name: file})
+		}
+
+		location := file
+		if issue.Line > 0 {
+			location = fmt.Sprintf("%s:%d", file, issue.Line)
+		}
+		suite := &report.TestSuite[index]
+		suite.Tests++
+		suite.Errors++
+		suite.TestCase = append(suite.TestCase, Testcase{
+			Name:      issue.Rule + " " + location,
+			Classname: file,
+			Failure: &Failure{
+				Message: "[" + issue.Severity + "] " + issue.Message,
+				Text: fmt.Sprintf("rule: %s (%s)\nseverity: %s\ntype: %s\nlocation: %s\nmessage: %s",
+					issue.Rule, issues.RuleName(issue.Rule), issue.Severity, issue.Type, location, issue.Message),
+			},
+		})
+	}
+	return report
+}
+
+// exportIssuesJunit writes the open issues of the analysed component as JUnit
+// to path, only those of the new code period when newCodeOnly is set.
+func exportIssuesJunit(ctx context.Context, path string, issueSource *issueSearch, newCodeOnly bool, projectName string) error {
+	issues, err := issueSource.get(ctx, newCodeOnly)
+	if err != nil {
+		return err
+	}
+	return writeIssuesJunit(path, issues, projectName)
+}
+
+// writeIssuesJunit writes the issues as JUnit to path.
+func writeIssuesJunit(path string, issues *IssuesResponse, projectName string) error {
+	file, _ := xml.MarshalIndent(issuesJunit(issues, projectName), "", " ")
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating report directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Printf("==> JUnit report with %d issues written to %s\n\n", len(issues.Issues), path)
+	return nil
+}
+
+// RuleName returns the name of a rule of the response, or its key when unknown.
+func (r *IssuesResponse) RuleName(key string) string {
+	for _, rule := range r.Rules {
+		if rule.Key == key && rule.Name != "" {
+			return rule.Name
+		}
+	}
+	return key
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIssuesJunit(t *testing.T) {
+	issues := &IssuesResponse{
+		Issues: []Issue{
+			{Key: "I1", Rule: "go:S1", Severity: "MAJOR", Type: "CODE_SMELL", File: "a.go", Line: 3, Message: "First"},
+			{Key: "I2", Rule: "go:S2", Severity: "BLOCKER", Type: "BUG", File: "b.go", Line: 7, Message: "Second"},
+			{Key: "I3", Rule: "go:S3", Severity: "MINOR", Type: "CODE_SMELL", File: "a.go", Line: 9, Message: "Third"},
+			{Key: "I4", Rule: "go:S4", Severity: "INFO", Type: "CODE_SMELL", Message: "Project"},
+		},
+		Rules: []IssueRule{{Key: "go:S2", Name: "Bugs should be fixed"}},
+	}
+
+	report := issuesJunit(issues, "project")
+	if len(report.TestSuite) != 3 {
+		t.Fatalf("Expected 3 testsuites, got %+v", report.TestSuite)
+	}
+	first := report.TestSuite[0]
+	if first.Name != "a.go" || first.Tests != 2 || first.Errors != 2 || first.TestCase[1].Name != "go:S3 a.go:9" {
+		t.Errorf("Unexpected testsuite %+v", first)
+	}
+	failure := report.TestSuite[1].TestCase[0].Failure
+	if failure.Message != "[BLOCKER] Second" || !strings.Contains(failure.Text, "Bugs should be fixed") || !strings.Contains(failure.Text, "b.go:7") {
+		t.Errorf("Unexpected failure %+v", failure)
+	}
+	if report.TestSuite[2].Name != "project" {
+		t.Errorf("Expected project level issues under the project key, got %s", report.TestSuite[2].Name)
+	}
+}
+
+func TestIssueSearchScopes(t *testing.T) {
+	requests := 0
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			requests++
+			body := `{"paging":{"pageIndex":1,"pageSize":500,"total":2},"issues":[{"key":"I1"},{"key":"I2"}]}`
+			if req.URL.Query().Get("inNewCodePeriod") == "true" {
+				body = `{"paging":{"pageIndex":1,"pageSize":500,"total":1},"issues":[{"key":"I2"}]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	search := newIssueSearch(client, url.Values{"component": {"project"}, "branch": {"main"}})
+	for i := 0; i < 2; i++ {
+		all, _ := search.get(context.Background(), false)
+		newCode, _ := search.get(context.Background(), true)
+		if len(all.Issues) != 2 || len(newCode.Issues) != 1 {
+			t.Fatalf("Unexpected issues %d all, %d new", len(all.Issues), len(newCode.Issues))
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected one request per scope, got %d", requests)
+	}
+}
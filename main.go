@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -108,7 +114,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "quality",
-			Usage:  "Quality Gate",
+			Usage:  "comma separated quality gate statuses that pass the step: OK, WARN, ERROR or NONE (no gate assigned)",
 			EnvVar: "SONAR_QUALITYGATE,PLUGIN_QUALITYGATE",
 			Value:  "OK",
 		},
@@ -132,7 +138,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "artifact_file",
-			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
+			Usage:  "write the analysis result as versioned JSON to this file, empty to disable",
 			Value:  "artifact.json",
 			EnvVar: "PLUGIN_ARTIFACT_FILE",
 		},
@@ -257,7 +263,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "taskid",
-			Usage:  "sonar analisys taskid",
+			Usage:  "Compute Engine task id or analysis id of the project to report, skips the scan",
 			Value:  "",
 			EnvVar: "PLUGIN_TASKID",
 		},
@@ -298,6 +304,166 @@ func main() {
 			Value:  5,
 			EnvVar: "PLUGIN_QUALITY_GATE_ERROR_EXIT_CODE",
 		},
+		cli.IntFlag{
+			Name:   "config_error_exit_code",
+			Usage:  "exit code when settings are missing or invalid",
+			Value:  2,
+			EnvVar: "PLUGIN_CONFIG_ERROR_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "auth_error_exit_code",
+			Usage:  "exit code when SonarQube rejects the token",
+			Value:  3,
+			EnvVar: "PLUGIN_AUTH_ERROR_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "unreachable_exit_code",
+			Usage:  "exit code when the SonarQube server cannot be reached or keeps answering 5xx",
+			Value:  4,
+			EnvVar: "PLUGIN_UNREACHABLE_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "scanner_error_exit_code",
+			Usage:  "exit code when sonar-scanner fails",
+			Value:  6,
+			EnvVar: "PLUGIN_SCANNER_ERROR_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "task_failed_exit_code",
+			Usage:  "exit code when the analysis task fails or is cancelled on the server",
+			Value:  7,
+			EnvVar: "PLUGIN_TASK_FAILED_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "timeout_exit_code",
+			Usage:  "exit code when the analysis task does not finish in time",
+			Value:  8,
+			EnvVar: "PLUGIN_TIMEOUT_EXIT_CODE",
+		},
+		cli.IntFlag{
+			Name:   "api_retries",
+			Usage:  "number of retries for SonarQube API calls failing with 429, 502, 503, 504 or a network error",
+			Value:  3,
+			EnvVar: "PLUGIN_API_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "api_retry_backoff",
+			Usage:  "initial delay between SonarQube API retries, doubled on every attempt",
+			Value:  time.Second,
+			EnvVar: "PLUGIN_API_RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "api_retry_max_backoff",
+			Usage:  "maximum delay between SonarQube API retries",
+			Value:  30 * time.Second,
+			EnvVar: "PLUGIN_API_RETRY_MAX_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "qualitygate_poll_interval",
+			Usage:  "initial delay between two checks of the analysis task, grows by half on every check",
+			Value:  2 * time.Second,
+			EnvVar: "PLUGIN_SONAR_QUALITYGATE_POLL_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "qualitygate_max_poll_interval",
+			Usage:  "maximum delay between two checks of the analysis task",
+			Value:  30 * time.Second,
+			EnvVar: "PLUGIN_SONAR_QUALITYGATE_MAX_POLL_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "qualitygate_queued_timeout",
+			Usage:  "maximum time the analysis task may stay queued (PENDING), 0 means only qualitygate_timeout applies",
+			EnvVar: "PLUGIN_SONAR_QUALITYGATE_QUEUED_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "qualitygate_in_progress_timeout",
+			Usage:  "maximum time the analysis task may stay IN_PROGRESS, 0 means only qualitygate_timeout applies",
+			EnvVar: "PLUGIN_SONAR_QUALITYGATE_IN_PROGRESS_TIMEOUT",
+		},
+		cli.BoolFlag{
+			Name:   "cancel_task_on_abort",
+			Usage:  "cancel the submitted analysis task on the server when the build is aborted (needs Administer permission)",
+			EnvVar: "PLUGIN_CANCEL_TASK_ON_ABORT",
+		},
+		cli.StringFlag{
+			Name:   "scanner_failure_policy",
+			Usage:  "what to do when sonar-scanner fails: fail (stop the step) or continue (report the last known quality gate)",
+			Value:  "fail",
+			EnvVar: "PLUGIN_SCANNER_FAILURE_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "gate_condition_policy",
+			Usage:  "comma separated metric=policy overrides of the quality gate conditions, policy is fail, warn or ignore",
+			EnvVar: "PLUGIN_GATE_CONDITION_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "metric_thresholds",
+			Usage:  "comma separated local metric thresholds checked on top of the quality gate, e.g. coverage>=80,new_bugs==0,sqale_rating<=2",
+			EnvVar: "PLUGIN_METRIC_THRESHOLDS",
+		},
+		cli.BoolFlag{
+			Name:   "new_code_only",
+			Usage:  "only failed new code (new_*) conditions fail the step, overall code conditions are reported as warnings",
+			EnvVar: "PLUGIN_NEW_CODE_ONLY",
+		},
+		cli.BoolFlag{
+			Name:   "ratchet",
+			Usage:  "fail when a ratchet metric gets worse than in the previous analysis of the same branch or pull request",
+			EnvVar: "PLUGIN_RATCHET",
+		},
+		cli.StringFlag{
+			Name:   "ratchet_metrics",
+			Usage:  "comma separated metrics checked by the ratchet",
+			Value:  "coverage,bugs,vulnerabilities,code_smells,duplicated_lines_density",
+			EnvVar: "PLUGIN_RATCHET_METRICS",
+		},
+		cli.Float64Flag{
+			Name:   "ratchet_tolerance",
+			Usage:  "how much a ratchet metric may get worse before the step fails",
+			EnvVar: "PLUGIN_RATCHET_TOLERANCE",
+		},
+		cli.StringFlag{
+			Name:   "badges",
+			Usage:  "comma separated metric=path SVG badges to write, quality_gate for the gate status, e.g. quality_gate=badges/gate.svg,coverage=badges/coverage.svg",
+			EnvVar: "PLUGIN_BADGES",
+		},
+		cli.StringFlag{
+			Name:   "sarif_file",
+			Usage:  "write the open issues of the analysed branch or pull request as SARIF 2.1.0 to this file",
+			EnvVar: "PLUGIN_SARIF_FILE",
+		},
+		cli.StringFlag{
+			Name:   "junit_issues_file",
+			Usage:  "write the open issues as JUnit to this file, one testsuite per file and one failing testcase per issue",
+			EnvVar: "PLUGIN_JUNIT_ISSUES_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "junit_issues_new_code_only",
+			Usage:  "only write the issues of the new code period to junit_issues_file",
+			EnvVar: "PLUGIN_JUNIT_ISSUES_NEW_CODE_ONLY",
+		},
+		cli.StringFlag{
+			Name:   "markdown_file",
+			Usage:  "write a markdown summary of the quality gate, measures and new issues to this file",
+			EnvVar: "PLUGIN_MARKDOWN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "html_file",
+			Usage:  "write a self-contained HTML report of the quality gate, measures and open issues to this file",
+			EnvVar: "PLUGIN_HTML_FILE",
+		},
+		cli.StringFlag{
+			Name:   "comparison_markdown_file",
+			Usage:  "write the pull request comparison as markdown to this file, empty disables it",
+			EnvVar: "PLUGIN_COMPARISON_MARKDOWN_FILE",
+			Value:  "sonarComparison.md",
+		},
+		cli.StringFlag{
+			Name:   "comparison_json_file",
+			Usage:  "write the pull request comparison as JSON to this file, empty disables it",
+			EnvVar: "PLUGIN_COMPARISON_JSON_FILE",
+			Value:  "sonarComparison.json",
+		},
 	}
 	app.Run(os.Args)
 }
@@ -352,14 +518,129 @@ func run(c *cli.Context) {
 			UseSonarConfigFile:         c.Bool("sonar_config_file"),
 			UseSonarConfigFileOverride: c.Bool("sonar_config_file_override"),
 			QualityGateErrorExitCode:   c.Int("quality_gate_error_exit_code"),
+			ConfigErrorExitCode:        c.Int("config_error_exit_code"),
+			AuthErrorExitCode:          c.Int("auth_error_exit_code"),
+			UnreachableExitCode:        c.Int("unreachable_exit_code"),
+			ScannerErrorExitCode:       c.Int("scanner_error_exit_code"),
+			TaskFailedExitCode:         c.Int("task_failed_exit_code"),
+			TimeoutExitCode:            c.Int("timeout_exit_code"),
+			APIRetries:                 c.Int("api_retries"),
+			APIRetryBackoff:            c.Duration("api_retry_backoff"),
+			APIRetryMaxBackoff:         c.Duration("api_retry_max_backoff"),
+			PollInterval:               c.Duration("qualitygate_poll_interval"),
+			MaxPollInterval:            c.Duration("qualitygate_max_poll_interval"),
+			QueuedTimeout:              c.Duration("qualitygate_queued_timeout"),
+			InProgressTimeout:          c.Duration("qualitygate_in_progress_timeout"),
+			CancelTaskOnAbort:          c.Bool("cancel_task_on_abort"),
+			ScannerFailurePolicy:       c.String("scanner_failure_policy"),
+			QualityGateType:            c.String("quality_gate_type"),
+			ConditionPolicies:          c.String("gate_condition_policy"),
+			MetricThresholds:           c.String("metric_thresholds"),
+			NewCodeOnly:                c.Bool("new_code_only"),
+			Ratchet:                    c.Bool("ratchet"),
+			RatchetMetrics:             c.String("ratchet_metrics"),
+			RatchetTolerance:           c.Float64("ratchet_tolerance"),
+			Badges:                     c.String("badges"),
+			SarifFile:                  c.String("sarif_file"),
+			JunitIssuesFile:            c.String("junit_issues_file"),
+			JunitIssuesNewCodeOnly:     c.Bool("junit_issues_new_code_only"),
+			MarkdownFile:               c.String("markdown_file"),
+			HTMLFile:                   c.String("html_file"),
+			ComparisonMarkdownFile:     c.String("comparison_markdown_file"),
+			ComparisonJSONFile:         c.String("comparison_json_file"),
 		},
 		Output: Output{
 			OutputFile: c.String("output-file"),
 		},
 	}
 	os.Setenv("TOKEN", base64.StdEncoding.EncodeToString([]byte(c.String("token")+":")))
-	if err := plugin.Exec(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := plugin.Exec(ctx)
+	code := 0
+	if err != nil {
+		code = exitCode(err, plugin.Config)
+		plugin.Output.Set(map[string]string{
+			"SONAR_FAILURE_CAUSE": failureCause(err),
+		})
+	}
+	plugin.Output.Set(map[string]string{
+		"SONAR_EXIT_CODE": strconv.Itoa(code),
+	})
+	if plugin.Config.ArtifactFile != "" {
+		plugin.Artifact.finish(plugin.Config, err, code)
+		if artifactErr := writeArtifact(plugin.Config.ArtifactFile, &plugin.Artifact); artifactErr != nil {
+			fmt.Println("Error writing artifact file:", artifactErr)
+		}
+	}
+	if flushErr := plugin.Output.Flush(); flushErr != nil {
+		fmt.Println("Error writing output file:", flushErr)
+	}
+	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(code)
+	}
+}
+
+// Failure causes exported as SONAR_FAILURE_CAUSE
+const (
+	causeConfig      = "config"
+	causeAuth        = "auth"
+	causeUnreachable = "unreachable"
+	causeScanner     = "scanner"
+	causeTaskFailed  = "task_failed"
+	causeTimeout     = "timeout"
+	causeQualityGate = "quality_gate"
+	causeAborted     = "aborted"
+	causeOther       = "error"
+)
+
+// failureCause classifies the error returned by Exec.
+func failureCause(err error) string {
+	var gateErr *QualityGateError
+	var scannerErr *ScannerError
+	var taskErr *TaskFailedError
+	switch {
+	case errors.As(err, &gateErr):
+		return causeQualityGate
+	case errors.Is(err, context.Canceled):
+		return causeAborted
+	case errors.Is(err, ErrConfig):
+		return causeConfig
+	case errors.As(err, &scannerErr):
+		return causeScanner
+	case errors.As(err, &taskErr):
+		return causeTaskFailed
+	case errors.Is(err, ErrWaitTimeout):
+		return causeTimeout
+	case errors.Is(err, ErrUnauthorized):
+		return causeAuth
+	case errors.Is(err, ErrUnreachable), errors.Is(err, ErrServerError):
+		return causeUnreachable
+	default:
+		return causeOther
+	}
+}
+
+// exitCode maps the error returned by Exec to the process exit code.
+func exitCode(err error, config Config) int {
+	switch failureCause(err) {
+	case causeQualityGate:
+		return config.QualityGateErrorExitCode
+	case causeConfig:
+		return config.ConfigErrorExitCode
+	case causeAuth:
+		return config.AuthErrorExitCode
+	case causeUnreachable:
+		return config.UnreachableExitCode
+	case causeScanner:
+		return config.ScannerErrorExitCode
+	case causeTaskFailed:
+		return config.TaskFailedExitCode
+	case causeTimeout:
+		return config.TimeoutExitCode
+	default:
+		return 1
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NewCodePeriod returns the new code period of the gate, from period or, on
+// servers before 8.1, the first entry of periods. It is nil when the server
+// did not send one.
+func (s Status) NewCodePeriod() *Period {
+	if s.Period != nil {
+		return s.Period
+	}
+	if len(s.Periods) > 0 {
+		return &s.Periods[0]
+	}
+	return nil
+}
+
+// Description tells in words what new code means for this period.
+func (p Period) Description() string {
+	switch strings.ToUpper(p.Mode) {
+	case "PREVIOUS_VERSION":
+		if p.Parameter != "" {
+			return "changes since version " + p.Parameter
+		}
+		return "changes since the previous version"
+	case "NUMBER_OF_DAYS", "DAYS":
+		return "changes of the last " + p.Parameter + " days"
+	case "REFERENCE_BRANCH":
+		return "changes compared to branch " + p.Parameter
+	case "VERSION":
+		return "changes since version " + p.Parameter
+	case "DATE":
+		if p.Parameter == "" {
+			return "changes since " + p.Date
+		}
+		return "changes since " + p.Parameter
+	case "SPECIFIC_ANALYSIS":
+		return "changes since analysis " + p.Parameter
+	case "PREVIOUS_ANALYSIS":
+		return "changes since the previous analysis"
+	}
+	return "new code period " + p.Mode + " " + p.Parameter
+}
+
+// periodOutputVars returns the new code period variables to export, empty when
+// the server did not send a period.
+func periodOutputVars(period *Period) map[string]string {
+	if period == nil {
+		period = &Period{}
+	}
+	return map[string]string{
+		"SONAR_NEW_CODE_PERIOD_MODE":      period.Mode,
+		"SONAR_NEW_CODE_PERIOD_DATE":      period.Date,
+		"SONAR_NEW_CODE_PERIOD_PARAMETER": period.Parameter,
+	}
+}
+
+// periodProperties returns the new code period as JUnit testsuite properties.
+func periodProperties(period *Period) *Properties {
+	if period == nil {
+		return nil
+	}
+	return &Properties{Property: []Property{
+		{Name: "sonar.newCodePeriod.mode", Value: period.Mode},
+		{Name: "sonar.newCodePeriod.date", Value: period.Date},
+		{Name: "sonar.newCodePeriod.parameter", Value: period.Parameter},
+		{Name: "sonar.newCodePeriod.description", Value: period.Description()},
+	}}
+}
+
+// displayNewCodePeriod prints what new code meant for the new_* conditions.
+func displayNewCodePeriod(period *Period) {
+	fmt.Println(lineBreak2)
+	fmt.Println("|  NEW CODE PERIOD                                               |")
+	fmt.Println(lineBreak2)
+	if period == nil {
+		fmt.Printf("| %-62s |\n", "not reported by the server")
+		fmt.Println(lineBreak2)
+		fmt.Println("")
+		return
+	}
+	fmt.Printf("| %-12s | %-47s |\n", "MODE", period.Mode)
+	fmt.Printf("| %-12s | %-47s |\n", "DATE", period.Date)
+	fmt.Printf("| %-12s | %-47s |\n", "PARAMETER", period.Parameter)
+	fmt.Printf("| %-12s | %-47s |\n", "NEW CODE", period.Description())
+	fmt.Println(lineBreak2)
+	fmt.Println("")
+}
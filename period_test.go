@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestNewCodePeriod(t *testing.T) {
+	var current, legacy Project
+	if err := json.Unmarshal([]byte(`{"projectStatus":{"status":"OK","period":{"mode":"REFERENCE_BRANCH","date":"2023-01-02T10:00:00+0000","parameter":"main"}}}`), &current); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"projectStatus":{"status":"OK","periods":[{"index":1,"mode":"days","date":"2017-10-19T13:00:00+0000","parameter":"30"}]}}`), &legacy); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	period := current.ProjectStatus.NewCodePeriod()
+	if period == nil || period.Parameter != "main" || period.Description() != "changes compared to branch main" {
+		t.Errorf("Unexpected period %+v", period)
+	}
+	period = legacy.ProjectStatus.NewCodePeriod()
+	if period == nil || period.Mode != "days" || period.Description() != "changes of the last 30 days" {
+		t.Errorf("Unexpected legacy period %+v", period)
+	}
+	if (Status{}).NewCodePeriod() != nil {
+		t.Error("Expected no period")
+	}
+
+	if vars := periodOutputVars(nil); vars["SONAR_NEW_CODE_PERIOD_MODE"] != "" {
+		t.Errorf("Expected empty variables without period, got %v", vars)
+	}
+
+	gate := evaluateGate(&current, nil)
+	out, _ := xml.Marshal(ParseJunit(gate, "project"))
+	if !strings.Contains(string(out), `<property name="sonar.newCodePeriod.mode" value="REFERENCE_BRANCH"></property>`) {
+		t.Errorf("Expected the period in the JUnit properties, got %s", out)
+	}
+}
+
+func TestPeriodDescription(t *testing.T) {
+	tests := []struct {
+		period   Period
+		expected string
+	}{
+		{Period{Mode: "PREVIOUS_VERSION", Parameter: "1.2"}, "changes since version 1.2"},
+		{Period{Mode: "PREVIOUS_VERSION"}, "changes since the previous version"},
+		{Period{Mode: "NUMBER_OF_DAYS", Parameter: "30"}, "changes of the last 30 days"},
+		{Period{Mode: "REFERENCE_BRANCH", Parameter: "main"}, "changes compared to branch main"},
+		{Period{Mode: "SPECIFIC_ANALYSIS", Parameter: "AXanalysis"}, "changes since analysis AXanalysis"},
+		{Period{Mode: "version", Parameter: "2.0"}, "changes since version 2.0"},
+		{Period{Mode: "date", Parameter: "2023-01-01"}, "changes since 2023-01-01"},
+		{Period{Mode: "date", Date: "2023-01-01T00:00:00+0000"}, "changes since 2023-01-01T00:00:00+0000"},
+		{Period{Mode: "PREVIOUS_ANALYSIS"}, "changes since the previous analysis"},
+	}
+	for _, test := range tests {
+		if description := test.period.Description(); description != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.period, test.expected, description)
+		}
+	}
+}
@@ -2,8 +2,7 @@ package main
 
 // Standard library imports
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -16,10 +15,10 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/pelletier/go-toml"
 	"github.com/sirupsen/logrus"
 )
 
@@ -36,8 +35,25 @@ var (
 	// sonarDashStatic is a static string used in the dashboard URL.
 	sonarDashStatic = "/dashboard?id="
 	//https://sonar.dfinsolutions.com/dashboard?id=dfinsolutions_Saturn-UI_AYezvlRKNrcjU-xpGTBl&pullRequest=1244
-	// basicAuth is the basic authentication string.
-	basicAuth = "Basic "
+
+	// Compute Engine task statuses
+	taskPending    = "PENDING"
+	taskInProgress = "IN_PROGRESS"
+	taskSuccess    = "SUCCESS"
+	taskFailed     = "FAILED"
+	taskCanceled   = "CANCELED"
+
+	// ErrConfig is returned when mandatory settings are missing or invalid.
+	ErrConfig = errors.New("invalid configuration")
+
+	// ErrWaitTimeout is returned when the Compute Engine task does not finish in time.
+	ErrWaitTimeout = errors.New("timed out waiting for sonar job")
+)
+
+// scanner_failure_policy values
+const (
+	scannerFailureFail     = "fail"
+	scannerFailureContinue = "continue"
 )
 
 const (
@@ -95,21 +111,64 @@ type (
 		UseSonarConfigFile         bool
 		UseSonarConfigFileOverride bool
 		QualityGateErrorExitCode   int
+		ConfigErrorExitCode        int
+		AuthErrorExitCode          int
+		UnreachableExitCode        int
+		ScannerErrorExitCode       int
+		TaskFailedExitCode         int
+		TimeoutExitCode            int
+		APIRetries                 int
+		APIRetryBackoff            time.Duration
+		APIRetryMaxBackoff         time.Duration
+		PollInterval               time.Duration
+		MaxPollInterval            time.Duration
+		QueuedTimeout              time.Duration
+		InProgressTimeout          time.Duration
+		CancelTaskOnAbort          bool
+		ScannerFailurePolicy       string
+		QualityGateType            string
+		ConditionPolicies          string
+		MetricThresholds           string
+		NewCodeOnly                bool
+		Ratchet                    bool
+		RatchetMetrics             string
+		RatchetTolerance           float64
+		Badges                     string
+		SarifFile                  string
+		JunitIssuesFile            string
+		JunitIssuesNewCodeOnly     bool
+		MarkdownFile               string
+		HTMLFile                   string
+		ComparisonMarkdownFile     string
+		ComparisonJSONFile         string
 	}
 	Output struct {
-		OutputFile string // File where plugin output are saved
+		OutputFile string            // File where plugin output are saved
+		vars       map[string]string // variables waiting for Flush
+	}
+	// WaitOptions controls how long and how often waitForSonarJob polls the Compute Engine task.
+	WaitOptions struct {
+		Timeout           time.Duration // overall budget, from qualitygate_timeout
+		QueuedTimeout     time.Duration // budget while the task is PENDING, 0 means no limit
+		InProgressTimeout time.Duration // budget while the task is IN_PROGRESS, 0 means no limit
+		PollInterval      time.Duration // first delay between two checks
+		MaxPollInterval   time.Duration // the delay grows up to this value
 	}
 	// SonarReport it is the representation of .scannerwork/report-task.txt //
 	SonarReport struct {
-		ProjectKey   string `toml:"projectKey"`
-		ServerURL    string `toml:"serverUrl"`
-		DashboardURL string `toml:"dashboardUrl"`
-		CeTaskID     string `toml:"ceTaskId"`
-		CeTaskURL    string `toml:"ceTaskUrl"`
+		ProjectKey    string // projectKey
+		ServerURL     string // serverUrl
+		ServerVersion string // serverVersion
+		Branch        string // branch
+		PullRequest   string // pullRequest
+		DashboardURL  string // dashboardUrl
+		CeTaskID      string // ceTaskId
+		CeTaskURL     string // ceTaskUrl
 	}
 	Plugin struct {
-		Config Config
-		Output Output // Output file content
+		Config   Config
+		Output   Output   // Output file content
+		Artifact Artifact // artifact_file content
 	}
 	// TaskResponse Give Compute Engine task details such as type, status, duration and associated component.
 	TaskResponse struct {
@@ -121,6 +180,8 @@ type (
 			ComponentName      string   `json:"componentName"`
 			ComponentQualifier string   `json:"componentQualifier"`
 			AnalysisID         string   `json:"analysisId"`
+			Branch             string   `json:"branch"`
+			PullRequest        string   `json:"pullRequest"`
 			Status             string   `json:"status"`
 			SubmittedAt        string   `json:"submittedAt"`
 			SubmitterLogin     string   `json:"submitterLogin"`
@@ -130,16 +191,38 @@ type (
 			HasScannerContext  bool     `json:"hasScannerContext"`
 			WarningCount       int      `json:"warningCount"`
 			Warnings           []string `json:"warnings"`
+			ErrorMessage       string   `json:"errorMessage"`
+			ErrorType          string   `json:"errorType"`
+			ErrorStacktrace    string   `json:"errorStacktrace"`
+			ScannerContext     string   `json:"scannerContext"`
 		} `json:"task"`
 	}
 
-	// ProjectStatusResponse Get the quality gate status of a project or a Compute Engine task
-	ProjectStatusResponse struct {
-		ProjectStatus struct {
-			Status string `json:"status"`
-		} `json:"projectStatus"`
+	// QualityGateError is returned when the quality gate status is not the expected one.
+	QualityGateError struct {
+		Status string
 	}
 
+	// ScannerError is returned when sonar-scanner exits with a non-zero status.
+	ScannerError struct {
+		ExitCode int
+		Err      error
+	}
+
+	// TaskFailedError is returned when the Compute Engine task ends FAILED or CANCELED.
+	TaskFailedError struct {
+		Task *TaskResponse
+	}
+
+	// analysisRef identifies the analysis whose quality gate was read.
+	analysisRef struct {
+		ID          string        // empty when looked up by branch or pull request
+		Task        *TaskResponse // Compute Engine task of the analysis, nil when unknown
+		Branch      string        // branch of the analysis, when known
+		PullRequest string        // pull request of the analysis, when known
+	}
+
+	// Project Get the quality gate status of a project or a Compute Engine task
 	Project struct {
 		ProjectStatus Status `json:"projectStatus"`
 	}
@@ -148,8 +231,16 @@ type (
 		Status            string      `json:"status"`
 		Conditions        []Condition `json:"conditions"`
 		IgnoredConditions bool        `json:"ignoredConditions"`
-		// Periods           []Period    `json:"periods,omitempty"` // some responses don't have this, so it's marked as omitempty
-		// Period            *Period     `json:"period,omitempty"` // some responses don't have this, so it's marked as omitempty
+		Periods           []Period    `json:"periods,omitempty"` // servers before 8.1 send the new code period here
+		Period            *Period     `json:"period,omitempty"`  // some responses don't have this, so it's marked as omitempty
+	}
+
+	// Period is the new code period the new_* conditions were evaluated on.
+	Period struct {
+		Index     int    `json:"index,omitempty"`
+		Mode      string `json:"mode"`      // PREVIOUS_VERSION, NUMBER_OF_DAYS, REFERENCE_BRANCH, SPECIFIC_ANALYSIS...
+		Date      string `json:"date"`      // start of the new code period
+		Parameter string `json:"parameter"` // version, number of days, branch or analysis depending on mode
 	}
 
 	Condition struct {
@@ -167,13 +258,21 @@ type (
 		TestSuite []Testsuite `xml:"testsuite"`
 	}
 	Testsuite struct {
-		Text     string     `xml:",chardata"`
-		Package  string     `xml:"package,attr"`
-		Time     int        `xml:"time,attr"`
-		Tests    int        `xml:"tests,attr"`
-		Errors   int        `xml:"errors,attr"`
-		Name     string     `xml:"name,attr"`
-		TestCase []Testcase `xml:"testcase"`
+		Text       string      `xml:",chardata"`
+		Package    string      `xml:"package,attr"`
+		Time       int         `xml:"time,attr"`
+		Tests      int         `xml:"tests,attr"`
+		Errors     int         `xml:"errors,attr"`
+		Name       string      `xml:"name,attr"`
+		Properties *Properties `xml:"properties"`
+		TestCase   []Testcase  `xml:"testcase"`
+	}
+	Properties struct {
+		Property []Property `xml:"property"`
+	}
+	Property struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
 	}
 
 	Testcase struct {
@@ -182,6 +281,11 @@ type (
 		Name      string   `xml:"name,attr"`      // Metric Key
 		Classname string   `xml:"classname,attr"` // The metric Rule
 		Failure   *Failure `xml:"failure"`        // Sonar Failure - show results
+		Skipped   *Skipped `xml:"skipped"`        // Condition ignored by gate_condition_policy
+		SystemOut string   `xml:"system-out,omitempty"`
+	}
+	Skipped struct {
+		Message string `xml:"message,attr"`
 	}
 	Failure struct {
 		Text    string `xml:",chardata"`
@@ -189,12 +293,111 @@ type (
 	}
 )
 
+// MeasuresResponse Return component with specified measures
+type MeasuresResponse struct {
+	Component struct {
+		Key      string    `json:"key"`
+		Name     string    `json:"name"`
+		Measures []Measure `json:"measures"`
+	} `json:"component"`
+}
+
+// Measure is the value of one metric. New code metrics carry their value in
+// period (or periods on servers older than 8.1) instead of value.
+type Measure struct {
+	Metric  string          `json:"metric"`
+	Value   string          `json:"value"`
+	Period  *MeasurePeriod  `json:"period,omitempty"`
+	Periods []MeasurePeriod `json:"periods,omitempty"`
+}
+
+type MeasurePeriod struct {
+	Index int    `json:"index"`
+	Value string `json:"value"`
+}
+
+// CurrentValue returns the measure value, whether it is an overall or a new code metric.
+func (m Measure) CurrentValue() string {
+	if m.Value != "" {
+		return m.Value
+	}
+	if m.Period != nil {
+		return m.Period.Value
+	}
+	if len(m.Periods) > 0 {
+		return m.Periods[0].Value
+	}
+	return ""
+}
+
+// Values returns the current value of every measure by metric key.
+func (r *MeasuresResponse) Values() map[string]string {
+	values := map[string]string{}
+	for _, measure := range r.Component.Measures {
+		values[measure.Metric] = measure.CurrentValue()
+	}
+	return values
+}
+
+// MeasuresHistoryResponse Search measures history of a component, oldest first
+type MeasuresHistoryResponse struct {
+	Paging   Paging `json:"paging"`
+	Measures []struct {
+		Metric  string `json:"metric"`
+		History []struct {
+			Date  string `json:"date"`
+			Value string `json:"value"`
+		} `json:"history"`
+	} `json:"measures"`
+}
+
+// Paging is the paging information of the search endpoints.
+type Paging struct {
+	PageIndex int `json:"pageIndex"`
+	PageSize  int `json:"pageSize"`
+	Total     int `json:"total"`
+}
+
+// QualityGateByProjectResponse Get the quality gate of a project
+type QualityGateByProjectResponse struct {
+	QualityGate struct {
+		Name    string `json:"name"`
+		Default bool   `json:"default"`
+	} `json:"qualityGate"`
+}
+
+// QualityGateDefinition Display the details of a quality gate
+type QualityGateDefinition struct {
+	Name       string                    `json:"name"`
+	IsBuiltIn  bool                      `json:"isBuiltIn"`
+	IsDefault  bool                      `json:"isDefault"`
+	Conditions []GateDefinitionCondition `json:"conditions"`
+}
+
+// GateDefinitionCondition is a condition as defined in the quality gate.
+type GateDefinitionCondition struct {
+	Metric string `json:"metric"`
+	Op     string `json:"op"`
+	Error  string `json:"error"`
+}
+
+// AnalysisResponse Search a project analyses, newest first
 type AnalysisResponse struct {
+	Paging   Paging `json:"paging"`
 	Analyses []struct {
-		Key string `json:"key"`
+		Key  string `json:"key"`
+		Date string `json:"date"`
 	} `json:"analyses"`
 }
 
+// BranchesResponse List the branches of a project
+type BranchesResponse struct {
+	Branches []struct {
+		Name   string `json:"name"`
+		IsMain bool   `json:"isMain"`
+	} `json:"branches"`
+}
+
 func init() {
 	netClient = &http.Client{
 		Timeout: time.Second * 10,
@@ -220,20 +423,12 @@ func TryCatch(f func()) func() error {
 	}
 }
 
-// displaySummary provides a colorful summary of the results in the terminal.
-func displaySummary(total, passed, failed int, errors int, newErrors int, projectJSON []byte) {
+// displaySummary provides a colorful summary of the results in the terminal
+// and returns the result variables to export.
+func displaySummary(total, passed, failed int, errors int, newErrors int) map[string]string {
 	// Calculate the success rate
 	var successRate float64
 
-	// Get the path for DRONE_OUTPUT
-	droneOutputPath := os.Getenv("DRONE_OUTPUT")
-	fmt.Print("\nDRONE_OUTPUT var: " + droneOutputPath + "\n")
-	if droneOutputPath == "" {
-		fmt.Print("\nError: DRONE_OUTPUT environment variable not set.\n")
-		fmt.Print("\nError: Probably you are not running in Harness or Drone.\n")
-		// return
-	}
-
 	if total != 0 {
 		successRate = float64(passed) / float64(total) * 100
 	} else {
@@ -263,20 +458,8 @@ func displaySummary(total, passed, failed int, errors int, newErrors int, projec
 		"SONAR_RESULT_FAILED":       fmt.Sprintf("%d", failed),
 		"SONAR_RESULT_ERRORS":       fmt.Sprintf("%d", errors),
 		"SONAR_RESULT_NEW_ERRORS":   fmt.Sprintf("%d", newErrors),
-		// "SONAR_RESULT_JSON":         fmt.Sprintf("%d", string(projectJSON)),
-	}
-
-	// Write to the .env file
-	filePath := fmt.Sprintf(droneOutputPath)
-	err := writeEnvFile(vars, filePath)
-	if err != nil {
-		fmt.Println("Error writing to .env file:", err)
-		// return
 	}
 
-	fmt.Println("Successfully wrote to .env file")
-	// defer file.Close()
-	fmt.Println("Successfully closed .env file")
 	fmt.Print("\n\n")
 	// Display the table
 	fmt.Println(lineBreak)
@@ -289,9 +472,43 @@ func displaySummary(total, passed, failed int, errors int, newErrors int, projec
 	fmt.Printf("|      TOTAL                 |      %d         |\n", total)
 	fmt.Println(lineBreak)
 	fmt.Printf("\n\nCategorization: %s\n", category)
+
+	return vars
+}
+
+// Set queues variables for the output file, written by Flush.
+func (o *Output) Set(vars map[string]string) {
+	if o.vars == nil {
+		o.vars = map[string]string{}
+	}
+	for key, value := range vars {
+		o.vars[key] = value
+	}
+}
+
+// Flush writes the queued variables to the output file (DRONE_OUTPUT).
+func (o *Output) Flush() error {
+	if len(o.vars) == 0 {
+		return nil
+	}
+	fmt.Print("\nDRONE_OUTPUT var: " + o.OutputFile + "\n")
+	if o.OutputFile == "" {
+		fmt.Print("\nError: DRONE_OUTPUT environment variable not set.\n")
+		fmt.Print("\nError: Probably you are not running in Harness or Drone.\n")
+		return nil
+	}
+	return writeEnvFile(o.vars, o.OutputFile)
 }
 
 func writeEnvFile(vars map[string]string, outputPath string) error {
+	// Keep the variables already exported by earlier steps of the plugin
+	if existing, err := godotenv.Read(outputPath); err == nil {
+		for key, value := range vars {
+			existing[key] = value
+		}
+		vars = existing
+	}
+
 	// Use godotenv.Write() to write the vars map to the specified file
 	err := godotenv.Write(vars, outputPath)
 	if err != nil {
@@ -314,41 +531,39 @@ func writeEnvFile(vars map[string]string, outputPath string) error {
 	return nil
 }
 
-func ParseJunit(projectArray Project, projectName string) Testsuites {
+func ParseJunit(gate GateResult, projectName string) Testsuites {
 	failed := 0
 	total := 0
 	testCases := []Testcase{}
 	errors := 0
 	newErrors := 0
 
-	conditionsArray := projectArray.ProjectStatus.Conditions
-
-	for _, condition := range conditionsArray {
+	for _, condition := range gate.Conditions {
 		total += 1
-		if condition.Status != "OK" {
+		cond := &Testcase{
+			Name:      condition.MetricKey,
+			Classname: "Violate if " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold,
+		}
+		switch condition.Verdict {
+		case verdictOK:
+		case verdictIgnored:
+			cond.Skipped = &Skipped{Message: "Ignored by gate_condition_policy, server status " + condition.Status}
+		case verdictWarn:
+			if condition.Overridden() {
+				cond.SystemOut = "Reported as warning by gate_condition_policy: " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold
+				break
+			}
+			fallthrough
+		default:
 			failed += 1
 			if strings.HasPrefix(condition.MetricKey, "new_") {
 				newErrors += 1
 			}
-			cond := &Testcase{
-				Name:      condition.MetricKey,
-				Classname: "Violate if " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold,
-				Failure:   &Failure{Message: "Violated: " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold},
-			}
-			testCases = append(testCases, *cond)
-		} else {
-			cond := &Testcase{
-				Name:      condition.MetricKey,
-				Classname: "Violate if " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold,
-				Time:      0,
-			}
-			testCases = append(testCases, *cond)
+			cond.Failure = &Failure{Message: "Violated: " + condition.ActualValue + " is " + condition.Comparator + " " + condition.ErrorThreshold}
 		}
+		testCases = append(testCases, *cond)
 	}
 
-	// Display the summary
-	passed := total - failed // Corrected this line
-
 	os.Setenv("SONAR_RESULT_NEW_ERRORS", fmt.Sprintf("%d", newErrors))  // Set the number of new errors as an environment variable
 	os.Setenv("SONAR_RESULT_OVERALL_ERRORS", fmt.Sprintf("%d", errors)) // Set the number of errors as an environment variable
 
@@ -358,13 +573,24 @@ func ParseJunit(projectArray Project, projectName string) Testsuites {
 	} else if os.Getenv("PLUGIN_BRANCHANALYSIS") == "true" {
 		dashboardLink = os.Getenv("PLUGIN_SONAR_HOST") + sonarDashStatic + os.Getenv("PLUGIN_SONAR_KEY") + "&branch=" + os.Getenv("PLUGIN_BRANCH")
 	}
+	suiteName := dashboardLink
+	if gate.Name() != "" {
+		suiteName = "Quality Gate " + gate.Name() + " - " + dashboardLink
+	}
 	SonarJunitReport := &Testsuites{
 		TestSuite: []Testsuite{
 			Testsuite{
-				Time: 13, Package: projectName, Errors: errors, Tests: total, Name: dashboardLink, TestCase: testCases,
+				Time: 13, Package: projectName, Errors: errors, Tests: total, Name: suiteName,
+				Properties: periodProperties(gate.Period), TestCase: testCases,
 			},
 		},
 	}
+	if len(gate.Thresholds) > 0 {
+		SonarJunitReport.TestSuite = append(SonarJunitReport.TestSuite, thresholdsTestsuite(gate.Thresholds, projectName))
+	}
+	if len(gate.Ratchet) > 0 {
+		SonarJunitReport.TestSuite = append(SonarJunitReport.TestSuite, ratchetTestsuite(gate.Ratchet, projectName))
+	}
 
 	out, _ := xml.MarshalIndent(SonarJunitReport, " ", "  ")
 	fmt.Println(string(out))
@@ -373,15 +599,41 @@ func ParseJunit(projectArray Project, projectName string) Testsuites {
 	fmt.Println(string(out))
 	fmt.Printf("\n")
 
-	projectJSON, err := json.Marshal(projectArray)
-	if err != nil {
-		fmt.Println("Error marshalling project to JSON:", err)
-		// Handle error or return something meaningful
-	}
+	return *SonarJunitReport
+}
 
-	displaySummary(total, passed, failed, errors, newErrors, projectJSON)
+// thresholdsTestsuite returns one testcase per local metric threshold.
+func thresholdsTestsuite(results []ThresholdResult, projectName string) Testsuite {
+	suite := Testsuite{Package: projectName, Name: "Local metric thresholds"}
+	for _, result := range results {
+		testCase := Testcase{
+			Name:      result.Metric,
+			Classname: "Violate unless " + result.Threshold.String(),
+		}
+		if !result.Passed {
+			testCase.Failure = &Failure{Message: result.Message}
+			suite.Errors++
+		}
+		suite.Tests++
+		suite.TestCase = append(suite.TestCase, testCase)
+	}
+	return suite
+}
 
-	return *SonarJunitReport
+// summarizeJunit counts the total, failed and failed new code conditions of a JUnit report.
+func summarizeJunit(report Testsuites) (total int, failed int, newErrors int) {
+	for _, suite := range report.TestSuite {
+		for _, testCase := range suite.TestCase {
+			total++
+			if testCase.Failure != nil {
+				failed++
+				if strings.HasPrefix(testCase.Name, "new_") {
+					newErrors++
+				}
+			}
+		}
+	}
+	return total, failed, newErrors
 }
 
 func GetProjectKey(key string) string {
@@ -393,36 +645,72 @@ func logConfigInfo(configType, configValue string) {
 	fmt.Printf("==> %s: %s\n", configType, configValue)
 }
 
-func PreFlightGetLatestTaskID(config Config) (string, error) {
-	var statusID string
+// PreFlightGetLatestTaskID reads the quality gate of the analysis selected by
+// the configuration, and tells which analysis it was.
+func PreFlightGetLatestTaskID(ctx context.Context, client *SonarClient, config Config, opts WaitOptions) (*Project, analysisRef, error) {
+	var project *Project
+	var analysis analysisRef
 	var err error
 
-	if config.PRKey != "" {
+	if config.TaskId != "" {
+		logConfigInfo("Task ID", config.TaskId)
+		project, analysis, err = getStatusID(ctx, client, config, opts)
+	} else if config.PRKey != "" {
 		logConfigInfo("PR Key", config.PRKey)
-		statusID, err = getStatusV2("pr", config.PRKey, config.Host, config.Key)
+		project, err = getStatusV2(ctx, client, "pr", config.PRKey, config.Key)
 	} else if config.Branch != "" {
 		logConfigInfo("Branch", config.Branch)
-		statusID, err = getStatusV2("branch", config.Branch, config.Host, config.Key)
+		project, err = getStatusV2(ctx, client, "branch", config.Branch, config.Key)
 	} else {
 		logConfigInfo("Project Key", config.Key)
-		statusID, err = getStatusID(config.TaskId, config.Host, config.Key)
+		project, analysis, err = getStatusID(ctx, client, config, opts)
 	}
 
 	if err != nil {
 		fmt.Printf("\n\n==> Error getting the latest scanID\n\n")
 		fmt.Printf("Error: %s", err.Error())
-		return "", err
+		return nil, analysis, err
 	}
 
-	return statusID, nil
+	return project, analysis, nil
 }
 
-func (p Plugin) Exec() error {
+func (p *Plugin) Exec(ctx context.Context) error {
 	// Check if the sonar-project.properties file exists in the current directory
 	sonarConfigFile := "sonar-project.properties"
 
 	args := []string{}
 
+	if err := checkScannerFailurePolicy(p.Config.ScannerFailurePolicy); err != nil {
+		return err
+	}
+	policies, err := parseConditionPolicies(p.Config.ConditionPolicies)
+	if err != nil {
+		return err
+	}
+	thresholds, err := parseThresholds(p.Config.MetricThresholds)
+	if err != nil {
+		return err
+	}
+	accepted, err := parseAcceptedStatuses(p.Config.Quality)
+	if err != nil {
+		return err
+	}
+	badges, err := parseBadges(p.Config.Badges)
+	if err != nil {
+		return err
+	}
+	ratchetMetrics := []string{}
+	if p.Config.Ratchet {
+		ratchetMetrics, err = parseRatchetMetrics(p.Config.RatchetMetrics)
+		if err != nil {
+			return err
+		}
+		if len(ratchetMetrics) == 0 {
+			return fmt.Errorf("ratchet needs at least one metric in ratchet_metrics: %w", ErrConfig)
+		}
+	}
+
 	// Additional conditions for args
 	if len(p.Config.Verbose) >= 1 {
 		args = append(args, "-X")
@@ -432,7 +720,7 @@ func (p Plugin) Exec() error {
 		args = append(args, "-Dsonar.projectBaseDir="+p.Config.Workspace)
 	}
 
-	_, err := os.Stat(sonarConfigFile)
+	_, err = os.Stat(sonarConfigFile)
 
 	if os.IsNotExist(err) || !p.Config.UseSonarConfigFile {
 		// If the configuration file does not exist, use the default parameters
@@ -443,15 +731,11 @@ func (p Plugin) Exec() error {
 		}
 
 		if len(p.Config.Host) < 1 || len(p.Config.Token) < 1 {
-			fmt.Println("sonar_token and sonar_host params are mandatory.")
-			fmt.Println("Exiting with status 2")
-			os.Exit(2)
+			return fmt.Errorf("sonar_token and sonar_host params are mandatory: %w", ErrConfig)
 		}
 
 		if len(p.Config.Key) < 1 {
-			fmt.Println("sonar_key (prject key) param is mandatory.")
-			fmt.Println("Exiting with status 2")
-			os.Exit(2)
+			return fmt.Errorf("sonar_key (prject key) param is mandatory: %w", ErrConfig)
 		}
 
 		// Map of potential configurations
@@ -469,8 +753,6 @@ func (p Plugin) Exec() error {
 			"-Dsonar.showProfiling":                  p.Config.ShowProfiling,
 			"-Dsonar.java.binaries":                  p.Config.Binaries,
 			"-Dsonar.branch.name":                    p.Config.Branch,
-			"-Dsonar.qualitygate.wait":               strconv.FormatBool(p.Config.WaitQualityGate),
-			"-Dsonar.qualitygate.timeout":            p.Config.QualityTimeout,
 			"-Dsonar.javascript.lcov.reportPaths":    p.Config.JavascitptIcovReport,
 			"-Dsonar.coverage.jacoco.xmlReportPaths": p.Config.JacocoReportPath,
 			"-Dsonar.java.coveragePlugin":            p.Config.JavaCoveragePlugin,
@@ -517,6 +799,15 @@ func (p Plugin) Exec() error {
 		// Configuration file exists, let sonar-scanner use it without additional parameters
 		fmt.Println("Configuration file found. Using sonar-project.properties.")
 
+		projectProps, err := readPropertiesFile(sonarConfigFile)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Unable to parse sonar-project.properties")
+		} else {
+			applySonarProjectProperties(&p.Config, projectProps)
+		}
+
 		if len(p.Config.Host) >= 1 {
 			fmt.Println("OVERRIDING sonar.host.url=" + p.Config.Host)
 			args = append(args, "-Dsonar.host.url="+p.Config.Host)
@@ -526,10 +817,7 @@ func (p Plugin) Exec() error {
 			fmt.Println("OVERRIDING sonar.login=" + p.Config.Token)
 			args = append(args, "-Dsonar.login="+p.Config.Token)
 		} else {
-			fmt.Println("sonar_token param is also mandatory when using sonar-project.properties.")
-			fmt.Println("Exiting with status 2")
-			os.Exit(2)
-
+			return fmt.Errorf("sonar_token param is also mandatory when using sonar-project.properties: %w", ErrConfig)
 		}
 
 		if len(p.Config.Key) >= 1 && p.Config.UseSonarConfigFileOverride {
@@ -548,34 +836,90 @@ func (p Plugin) Exec() error {
 	fmt.Printf("sonar Arguments: %v\n\n", args)
 
 	status := ""
+	var project *Project
+	var client *SonarClient
+	var report *SonarReport
 	taskFilePath := ".scannerwork/report-task.txt"
 	if len(p.Config.Workspace) >= 1 {
 		taskFilePath = p.Config.Workspace + "/.scannerwork/report-task.txt"
 	}
 
-	if p.Config.TaskId != "" || p.Config.SkipScan {
-		fmt.Println("Skipping Scan...")
+	skipScan := p.Config.TaskId != "" || p.Config.SkipScan
+	if !skipScan {
+		fmt.Println("Starting Analysis")
 		fmt.Println("")
+
+		// A report left by a previous analysis must never be taken for ours
+		if err := os.Remove(taskFilePath); err != nil && !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Unable to remove previous " + taskFilePath)
+		}
+
+		watcher := &gateFailureWatcher{}
+		err := runScanner(ctx, args, watcher)
+		if ctx.Err() != nil {
+			fmt.Printf("\n\n==> Analysis aborted\n\n")
+			if p.Config.CancelTaskOnAbort {
+				p.cancelSubmittedAnalysis(taskFilePath)
+			}
+			return ctx.Err()
+		}
+		exitCode := scannerExitCode(err)
+		p.Artifact.ScannerExitCode = &exitCode
+		p.Output.Set(map[string]string{
+			"SONAR_SCANNER_EXIT_CODE": strconv.Itoa(exitCode),
+		})
+		if err != nil && watcher.Failed() && analysisSubmitted(taskFilePath) {
+			// sonar.qualitygate.wait in sonar-project.properties makes the
+			// scanner fail on a failed gate, the plugin evaluates it instead
+			fmt.Printf("\n\nsonar-scanner reported a failed quality gate (exit code %d), evaluating it.\n\n", exitCode)
+			err = nil
+		}
+		if err != nil {
+			fmt.Printf("\n\n==> Error in Analysis\n\n")
+			logConfigInfo("Error", err.Error())
+			logConfigInfo("Scanner exit code", strconv.Itoa(exitCode))
+			if p.Config.ScannerFailurePolicy != scannerFailureContinue {
+				return &ScannerError{ExitCode: exitCode, Err: err}
+			}
+			fmt.Println("scanner_failure_policy is continue, reporting the last known quality gate instead.")
+			fmt.Println("")
+			skipScan = true
+		}
+	}
+
+	if skipScan {
+		if p.Config.TaskId != "" || p.Config.SkipScan {
+			fmt.Println("Skipping Scan...")
+			fmt.Println("")
+		}
 		fmt.Println("Waiting for quality gate validation...")
 		fmt.Println("")
-		status, err = PreFlightGetLatestTaskID(p.Config)
+		client = p.sonarClient(p.Config.Host)
+		p.Artifact.ServerVersion = serverVersion(ctx, client)
+		var analysis analysisRef
+		project, analysis, err = PreFlightGetLatestTaskID(ctx, client, p.Config, p.waitOptions())
+		var taskErr *TaskFailedError
+		if errors.As(err, &taskErr) {
+			p.exportTaskFailure(taskErr.Task, p.Config.Key)
+		}
 		if err != nil {
 			fmt.Printf("\n\n==> Error getting the latest scanID\n\n")
 			logConfigInfo("Error", err.Error())
 			return err
 		}
-	} else {
-		fmt.Println("Starting Analysis")
-		fmt.Println("")
-		cmd := exec.Command("sonar-scanner", args...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf("\n\n==> Error in Analysis\n\n")
-			logConfigInfo("Error", err.Error())
-			// return err
+		if analysis.Task != nil {
+			p.Artifact.setTask(analysis.Task)
+		}
+		p.Artifact.AnalysisID = analysis.ID
+		// A taskid on another branch or pull request reports measures and
+		// issues of that branch or pull request
+		if p.Config.Branch == "" && p.Config.PRKey == "" {
+			p.Config.Branch = analysis.Branch
+			p.Config.PRKey = analysis.PullRequest
 		}
+	} else {
 		fmt.Println("")
 		fmt.Println("==> Sonar Analysis Finished!")
 		fmt.Println("")
@@ -584,25 +928,23 @@ func (p Plugin) Exec() error {
 		fmt.Println("")
 		fmt.Println("")
 
-		cmd = exec.Command("cat", taskFilePath)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
+		reportContent, err := os.ReadFile(taskFilePath)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Run command cat reportname failed")
-			return err
+			return fmt.Errorf("unable to read scan results: %w", err)
 		}
+		fmt.Println(string(reportContent))
 
 		fmt.Printf("\n\nParsing Results:\n\n")
 
-		report, err := staticScan(&p, taskFilePath)
+		report, err = staticScan(p, taskFilePath)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Unable to parse scan results!")
+			return fmt.Errorf("unable to parse scan results: %w", err)
 		}
+		p.Artifact.ProjectKey = report.ProjectKey
+		p.Artifact.ServerURL = report.ServerURL
+		p.Artifact.ServerVersion = report.ServerVersion
+		p.Artifact.DashboardURL = report.DashboardURL
+		p.Artifact.Task = &ArtifactTask{ID: report.CeTaskID}
 
 		if p.Config.WaitQualityGate {
 			logrus.WithFields(logrus.Fields{
@@ -610,24 +952,90 @@ func (p Plugin) Exec() error {
 			}).Info("Job url")
 			fmt.Printf("\n\nWaiting Analysis to finish:\n\n")
 
-			task, err := waitForSonarJob(report)
+			client = p.sonarClient(report.ServerURL)
+			task, err := waitForSonarJob(ctx, client, report, p.waitOptions())
+			if ctx.Err() != nil && p.Config.CancelTaskOnAbort {
+				cancelSonarJob(client, report.CeTaskID)
+			}
+			var taskErr *TaskFailedError
+			if errors.As(err, &taskErr) {
+				p.exportTaskFailure(taskErr.Task, report.ProjectKey)
+			}
 			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error": err,
-				}).Fatal("Unable to get Job state")
-				return err
+				return fmt.Errorf("unable to get Job state: %w", err)
 			}
+			p.Artifact.setTask(task)
 
 			fmt.Println("Waiting for quality gate validation...")
 			fmt.Println("")
 
-			status = getStatus(task, report)
+			project, err = getStatus(ctx, client, p.Config, task, report)
+			if err != nil {
+				return err
+			}
 		} else {
 			fmt.Println("Delaying for quality gate validation...")
 			fmt.Println("")
 			status = "OK"
 		}
 	}
+	if project != nil {
+		if p.Config.NewCodeOnly {
+			fmt.Println("new_code_only is set, failed overall code conditions are reported as warnings.")
+			policies = newCodeOnlyPolicies(project, policies)
+		}
+		component := analysisComponent(p.Config, report)
+		gate := evaluateGate(project, policies)
+		if len(thresholds) > 0 {
+			results, err := checkThresholds(ctx, client, thresholds, component)
+			if err != nil {
+				return err
+			}
+			gate.applyThresholds(results)
+		}
+		if p.Config.Ratchet {
+			results, err := checkRatchet(ctx, client, ratchetMetrics, p.Config.RatchetTolerance, component, p.Artifact.AnalysisID)
+			if err != nil {
+				return err
+			}
+			gate.applyRatchet(results)
+		}
+		gate.Definition = lookupGateDefinition(ctx, client, component.Get("component"), p.Config.Organization)
+		status = gate.Status
+		if err := p.exportQualityGate(project, gate); err != nil {
+			return err
+		}
+		if len(badges) > 0 {
+			warnReportFailure("badges", exportBadges(ctx, client, badges, gate, component))
+		}
+		issueSource := newIssueSearch(client, component)
+		if p.Config.SarifFile != "" {
+			serverVersion := ""
+			if report != nil {
+				serverVersion = report.ServerVersion
+			}
+			warnReportFailure("sarif_file", exportSarif(ctx, p.Config.SarifFile, issueSource, client.BaseURL, serverVersion))
+		}
+		if p.Config.JunitIssuesFile != "" {
+			warnReportFailure("junit_issues_file", exportIssuesJunit(ctx, p.Config.JunitIssuesFile, issueSource, p.Config.JunitIssuesNewCodeOnly, component.Get("component")))
+		}
+		analysis := newAnalysisReport(report, client.BaseURL, component, gate, accepted)
+		p.Artifact.ProjectKey = analysis.ProjectKey
+		p.Artifact.Branch = analysis.Branch
+		p.Artifact.PullRequest = analysis.PullRequest
+		p.Artifact.DashboardURL = analysis.DashboardURL
+		p.Artifact.setGate(gate)
+		if analysis.PullRequest != "" {
+			analysis.Comparison = p.exportComparison(ctx, client, analysis.ProjectKey, analysis.PullRequest)
+		}
+		if p.Config.MarkdownFile != "" {
+			warnReportFailure("markdown_file", exportMarkdownReport(ctx, client, p.Config.MarkdownFile, analysis, issueSource, component))
+		}
+		if p.Config.HTMLFile != "" {
+			warnReportFailure("html_file", exportHTMLReport(ctx, client, p.Config.HTMLFile, analysis, issueSource, component))
+		}
+	}
+
 	fmt.Println("")
 	fmt.Println("==> SONAR PROJECT DASHBOARD <==")
 	fmt.Println("")
@@ -635,34 +1043,223 @@ func (p Plugin) Exec() error {
 	fmt.Println("==> Harness CIE SonarQube Plugin with Quality Gateway <==")
 	fmt.Println("")
 
-	displayQualityGateStatus(status, p.Config.QualityEnabled == "true")
+	retries := 0
+	if client != nil {
+		retries = client.Retries
+	}
+	passed, reason := explainGateStatus(status, accepted)
+	displayQualityGateStatus(status, passed, p.Config.QualityEnabled == "true", retries)
+	fmt.Printf("Quality gate verdict: %s\n\n", reason)
+	p.Artifact.Reason = reason
+	if p.Artifact.QualityGate != nil {
+		p.Artifact.Verdict = artifactFailed
+		if passed {
+			p.Artifact.Verdict = artifactPassed
+		}
+	}
+	p.Output.Set(map[string]string{
+		"SONAR_GATE_VERDICT_REASON": reason,
+	})
 
-	if status != p.Config.Quality && p.Config.QualityEnabled == "true" {
+	if !passed && p.Config.QualityEnabled == "true" {
 		logrus.WithFields(logrus.Fields{
 			"status": status,
+			"reason": reason,
 		}).Info("QualityGate status failed. exiting...")
-		os.Exit(p.Config.QualityGateErrorExitCode)
+		return &QualityGateError{Status: status}
 	}
-	if status != p.Config.Quality && p.Config.QualityEnabled == "false" {
+	if !passed && p.Config.QualityEnabled == "false" {
 		logrus.WithFields(logrus.Fields{
 			"status": status,
+			"reason": reason,
 		}).Info("Quality Gate Status disabled")
 	}
-	if status == p.Config.Quality {
+	if passed {
 		logrus.WithFields(logrus.Fields{
 			"status": status,
+			"reason": reason,
 		}).Info("Quality Gate Status Success")
 	}
 
 	return nil
 }
 
-func displayQualityGateStatus(status string, qualityEnabled bool) {
+// sonarClient returns a Web API client for baseURL using the plugin token and retry settings.
+func (p Plugin) sonarClient(baseURL string) *SonarClient {
+	client := NewSonarClient(baseURL, p.Config.Token)
+	client.Retry = RetryPolicy{
+		MaxRetries:     p.Config.APIRetries,
+		InitialBackoff: p.Config.APIRetryBackoff,
+		MaxBackoff:     p.Config.APIRetryMaxBackoff,
+	}
+	return client
+}
+
+// scannerExitCode returns the exit status of a sonar-scanner run, or -1 when it could not run at all.
+func scannerExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func (e *QualityGateError) Error() string {
+	return fmt.Sprintf("quality gate status is %s", e.Status)
+}
+
+func (e *ScannerError) Error() string {
+	return fmt.Sprintf("sonar-scanner failed with exit code %d: %v", e.ExitCode, e.Err)
+}
+
+func (e *ScannerError) Unwrap() error {
+	return e.Err
+}
+
+// scannerStopGracePeriod is how long sonar-scanner may take to exit after SIGTERM.
+const scannerStopGracePeriod = 10 * time.Second
+
+// runScanner runs sonar-scanner in its own process group and stops the whole
+// group when ctx is cancelled, first with SIGTERM and then with SIGKILL. The
+// scanner output is also copied to output.
+func runScanner(ctx context.Context, args []string, output io.Writer) error {
+	cmd := exec.Command("sonar-scanner", args...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, output)
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Build aborted, stopping sonar-scanner...")
+	if err := terminateProcessGroup(cmd, false); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Unable to stop sonar-scanner")
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(scannerStopGracePeriod):
+		fmt.Println("sonar-scanner did not stop in time, killing it")
+		_ = terminateProcessGroup(cmd, true)
+		return <-done
+	}
+}
+
+// scannerGateFailed is logged by sonar-scanner when sonar.qualitygate.wait is
+// set and the quality gate fails.
+const scannerGateFailed = "QUALITY GATE STATUS: FAILED"
+
+// gateFailureWatcher watches the sonar-scanner output for scannerGateFailed.
+type gateFailureWatcher struct {
+	mu     sync.Mutex
+	tail   []byte // end of the previous write, the message may span writes
+	failed bool
+}
+
+func (w *gateFailureWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed {
+		return len(p), nil
+	}
+	data := append(w.tail, p...)
+	w.failed = strings.Contains(string(data), scannerGateFailed)
+	if keep := len(scannerGateFailed) - 1; len(data) > keep {
+		data = data[len(data)-keep:]
+	}
+	w.tail = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// Failed reports whether the scanner logged a failed quality gate.
+func (w *gateFailureWatcher) Failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failed
+}
+
+// warnReportFailure logs a report that could not be written. The reports are
+// informative, they never change the verdict of the step.
+func warnReportFailure(setting string, err error) {
+	if err == nil {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"report": setting,
+		"error":  err,
+	}).Warn("Unable to write the report")
+}
+
+// checkScannerFailurePolicy validates scanner_failure_policy.
+func checkScannerFailurePolicy(policy string) error {
+	if policy != scannerFailureFail && policy != scannerFailureContinue {
+		return fmt.Errorf("scanner_failure_policy must be fail or continue, got %q: %w", policy, ErrConfig)
+	}
+	return nil
+}
+
+// analysisSubmitted reports whether sonar-scanner wrote the report of a
+// submitted analysis to taskFilePath.
+func analysisSubmitted(taskFilePath string) bool {
+	_, err := os.Stat(taskFilePath)
+	return err == nil
+}
+
+// cancelSonarJob drops the submitted task from the server queue after the build was aborted.
+func cancelSonarJob(client *SonarClient, taskID string) {
+	if taskID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.CancelTask(ctx, taskID); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"task":  taskID,
+			"error": err,
+		}).Warn("Unable to cancel Sonar job")
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"task": taskID,
+	}).Info("Sonar job cancelled")
+}
+
+// cancelSubmittedAnalysis cancels the task of an analysis the scanner
+// submitted before the build was aborted, if report-task.txt shows one.
+func (p *Plugin) cancelSubmittedAnalysis(taskFilePath string) {
+	if _, err := os.Stat(taskFilePath); err != nil {
+		return
+	}
+	report, err := staticScan(p, taskFilePath)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Unable to read the submitted analysis to cancel it")
+		return
+	}
+	cancelSonarJob(p.sonarClient(report.ServerURL), report.CeTaskID)
+}
+
+func displayQualityGateStatus(status string, passed bool, qualityEnabled bool, apiRetries int) {
 	fmt.Println(lineBreak)
 	fmt.Printf("|         QUALITY GATE STATUS REPORT           |\n")
 	fmt.Println(lineBreak)
 
-	if status == "OK" {
+	if passed {
 		fmt.Printf("|         STATUS              |      \033[32m%s\033[0m       |\n", status)
 	} else {
 		fmt.Printf("|         STATUS              |      \033[31m%s\033[0m       |\n", status)
@@ -676,6 +1273,9 @@ func displayQualityGateStatus(status string, qualityEnabled bool) {
 		fmt.Printf("|      QUALITY GATE ENABLED   |       \033[31mNO\033[0m        |\n")
 	}
 
+	fmt.Println(lineBreak)
+	fmt.Printf("|      SONAR API RETRIES      |       %-8d|\n", apiRetries)
+
 	fmt.Printf("----------------------------------------------\n\n")
 	fmt.Println(lineBreak)
 	fmt.Printf("|         Developed by: Diego Pereira          |\n")
@@ -683,49 +1283,145 @@ func displayQualityGateStatus(status string, qualityEnabled bool) {
 }
 
 func staticScan(p *Plugin, taskFilePath string) (*SonarReport, error) {
+	props, err := readPropertiesFile(taskFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", taskFilePath, err)
+	}
+
+	report := SonarReport{
+		ProjectKey:    props["projectKey"],
+		ServerURL:     props["serverUrl"],
+		ServerVersion: props["serverVersion"],
+		Branch:        props["branch"],
+		PullRequest:   props["pullRequest"],
+		DashboardURL:  props["dashboardUrl"],
+		CeTaskID:      props["ceTaskId"],
+		CeTaskURL:     props["ceTaskUrl"],
+	}
+	if report.CeTaskID == "" || report.ServerURL == "" {
+		return nil, fmt.Errorf("%s has no ceTaskId or serverUrl", taskFilePath)
+	}
+
+	return &report, nil
+}
+
+// applySonarProjectProperties fills the settings the plugin needs after the
+// scan (host, project key, branch, pull request) from sonar-project.properties
+// when they are not given as plugin parameters.
+func applySonarProjectProperties(config *Config, props map[string]string) {
+	if key := props["sonar.projectKey"]; key != "" && (config.Key == "" || !config.UseSonarConfigFileOverride) {
+		config.Key = key
+		logConfigInfo("sonar.projectKey", key)
+	}
+	if host := props["sonar.host.url"]; host != "" && config.Host == "" {
+		config.Host = host
+		logConfigInfo("sonar.host.url", host)
+	}
+	if branch := props["sonar.branch.name"]; branch != "" && config.Branch == "" {
+		config.Branch = branch
+		logConfigInfo("sonar.branch.name", branch)
+	}
+	if pr := props["sonar.pullrequest.key"]; pr != "" && config.PRKey == "" {
+		config.PRKey = pr
+		logConfigInfo("sonar.pullrequest.key", pr)
+	}
+}
 
-	cmd := exec.Command("sed", "-e", "s/=/=\"/", "-e", "s/$/\"/", taskFilePath)
-	output, err := cmd.CombinedOutput()
+// analysisComponent returns the component, branch and pull request parameters
+// selecting the analysed code in the measures and issues APIs.
+func analysisComponent(config Config, report *SonarReport) url.Values {
+	params := url.Values{"component": {config.Key}}
+	if report != nil {
+		if report.ProjectKey != "" {
+			params.Set("component", report.ProjectKey)
+		}
+		if report.PullRequest != "" {
+			params.Set("pullRequest", report.PullRequest)
+		} else if report.Branch != "" {
+			params.Set("branch", report.Branch)
+		}
+		return params
+	}
+	if config.PRKey != "" {
+		params.Set("pullRequest", config.PRKey)
+	} else if config.Branch != "" {
+		params.Set("branch", config.Branch)
+	}
+	return params
+}
+
+// serverVersion returns the server version for the artifact when no
+// report-task.txt gave it. It is informative, failures are only logged.
+func serverVersion(ctx context.Context, client *SonarClient) string {
+	version, err := client.ServerVersion(ctx)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
-		}).Fatal("Run command sed failed")
-		return nil, err
+		}).Warn("Unable to get the server version")
+		return ""
 	}
-	report := SonarReport{}
-	err = toml.Unmarshal(output, &report)
+	return version
+}
 
+// lookupGateDefinition returns the quality gate assigned to the project with
+// its conditions. The definition is informative only, so failures are logged
+// and nil is returned.
+func lookupGateDefinition(ctx context.Context, client *SonarClient, projectKey string, organization string) *QualityGateDefinition {
+	assigned, err := client.QualityGateByProject(ctx, projectKey, organization)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
-		}).Fatal("Toml Unmarshal failed")
-		return nil, err
+		}).Warn("Unable to find the quality gate of the project")
+		return nil
+	}
+	definition, err := client.QualityGate(ctx, assigned.QualityGate.Name, organization)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"gate":  assigned.QualityGate.Name,
+		}).Warn("Unable to read the quality gate definition")
+		return &QualityGateDefinition{Name: assigned.QualityGate.Name}
 	}
+	return definition
+}
 
-	return &report, nil
+// checkThresholds fetches the measures of the analysed component and checks
+// the local metric thresholds against them.
+func checkThresholds(ctx context.Context, client *SonarClient, thresholds []Threshold, component url.Values) ([]ThresholdResult, error) {
+	fmt.Printf("==> Local metric thresholds request:\n")
+	fmt.Println(client.BaseURL + measuresComponentPath + "?" + component.Encode())
+	fmt.Printf("\n")
+
+	measures, err := client.Measures(ctx, component, thresholdMetrics(thresholds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get measures for metric_thresholds: %w", err)
+	}
+	results := evaluateThresholds(thresholds, measures.Values())
+	displayThresholds(results)
+	return results, nil
 }
 
-func getStatus(task *TaskResponse, report *SonarReport) string {
+func getStatus(ctx context.Context, client *SonarClient, config Config, task *TaskResponse, report *SonarReport) (*Project, error) {
 
-	qg_type := os.Getenv("PLUGIN_QG_TYPE")
-	qg_projectKey := os.Getenv("PLUGIN_SONAR_KEY")
+	qg_projectKey := config.Key
+	if qg_projectKey == "" {
+		qg_projectKey = report.ProjectKey
+	}
 
 	var reportRequest url.Values
 
-	if qg_type == "branch" {
-		qg_branch := os.Getenv("PLUGIN_BRANCH")
+	if config.QualityGateType == "branch" {
 		reportRequest = url.Values{
-			"branch":     {qg_branch},
+			"branch":     {config.Branch},
 			"projectKey": {qg_projectKey},
 		}
-	} else if qg_type == "pullRequest" {
-		qg_pr := os.Getenv("PLUGIN_PR_KEY")
+	} else if config.QualityGateType == "pullRequest" {
 		reportRequest = url.Values{
-			"pullRequest": {qg_pr},
+			"pullRequest": {config.PRKey},
 			"projectKey":  {qg_projectKey},
 		}
 
-	} else if qg_type == "projectKey" {
+	} else if config.QualityGateType == "projectKey" {
 		reportRequest = url.Values{
 			"projectKey": {qg_projectKey},
 		}
@@ -735,146 +1431,203 @@ func getStatus(task *TaskResponse, report *SonarReport) string {
 		}
 	}
 
-	sonarToken := os.Getenv("PLUGIN_SONAR_TOKEN")
-
-	// First try with Basic Auth
-	projectRequest, err := http.NewRequest("GET", report.ServerURL+"/api/qualitygates/project_status?"+reportRequest.Encode(), nil)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed get status")
-	}
 	fmt.Printf("==> Job Quality Gate Request:\n")
-	fmt.Printf(report.ServerURL + "/api/qualitygates/project_status?" + reportRequest.Encode())
-	fmt.Printf("\n")
+	fmt.Println(client.BaseURL + projectStatusPath + "?" + reportRequest.Encode())
 	fmt.Printf("\n")
-	projectRequest.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(sonarToken+":")))
-	projectResponse, err := netClient.Do(projectRequest)
-
-	if err != nil || projectResponse.StatusCode != http.StatusOK {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Info("Failed to get status with Basic Auth, retrying with Bearer token...")
 
-		// Retry with Bearer token
-		projectRequest.Header.Set("Authorization", "Bearer "+sonarToken)
-		projectResponse, err = netClient.Do(projectRequest)
-
-		if err != nil || projectResponse.StatusCode != http.StatusOK {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Failed to get status after retry with Bearer token")
-		}
+	project, err := client.ProjectStatus(ctx, reportRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quality gate status: %w", err)
 	}
 
-	buf, _ := io.ReadAll(projectResponse.Body)
-	fmt.Printf("==> Report Result:\n")
-	fmt.Println(string(buf))
-	fmt.Printf("\n")
-	project := ProjectStatusResponse{}
-	if err := json.Unmarshal(buf, &project); err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed")
-	}
-	fmt.Printf("==> Report Result:\n")
-	fmt.Println(string(buf))
+	return project, nil
+}
 
-	// JUNUT
-	junitReport := ""
-	junitReport = string(buf) // returns a string of what was written to it
+// exportQualityGate prints the quality gate conditions, writes them to
+// sonarResults.xml as JUnit and queues the result variables.
+func (p *Plugin) exportQualityGate(project *Project, gate GateResult) error {
 	fmt.Println(lineBreak)
 	fmt.Printf("|      SONAR SCAN + JUNIT EXPORTER PLUGIN      |\n")
 	fmt.Print("----------------------------------------------\n\n\n")
-	bytesReport := []byte(junitReport)
-	var projectReport Project
-	err = json.Unmarshal(bytesReport, &projectReport)
-	if err != nil {
-		panic(err)
-	}
 
-	fmt.Printf("%+v", projectReport)
+	fmt.Printf("%+v", *project)
 	fmt.Printf("\n")
-	result := ParseJunit(projectReport, qg_projectKey)
+	result := ParseJunit(gate, p.Config.Key)
+	total, failed, newErrors := summarizeJunit(result)
+	p.Output.Set(displaySummary(total, total-failed, failed, 0, newErrors))
+	displayGateDefinition(gate)
+	displayNewCodePeriod(gate.Period)
+	displayGateOverrides(gate)
+	p.Output.Set(periodOutputVars(gate.Period))
+
+	overridden := []string{}
+	for _, condition := range gate.Overridden() {
+		overridden = append(overridden, condition.MetricKey+"="+condition.Policy)
+	}
+	regressions := []string{}
+	for _, result := range gate.Regressions() {
+		regressions = append(regressions, result.Metric)
+	}
+	failedThresholds := []string{}
+	for _, threshold := range gate.FailedThresholds() {
+		failedThresholds = append(failedThresholds, threshold.Threshold.String())
+	}
+	p.Output.Set(map[string]string{
+		"SONAR_GATE_NAME":                  gate.Name(),
+		"SONAR_GATE_SERVER_STATUS":         gate.ServerStatus,
+		"SONAR_GATE_STATUS":                gate.Status,
+		"SONAR_GATE_OVERRIDDEN_CONDITIONS": strings.Join(overridden, ","),
+		"SONAR_THRESHOLDS_FAILED":          strings.Join(failedThresholds, ","),
+		"SONAR_RATCHET_REGRESSIONS":        strings.Join(regressions, ","),
+	})
+
 	file, _ := xml.MarshalIndent(result, "", " ")
-	_ = ioutil.WriteFile("sonarResults.xml", file, 0644)
+	if err := os.WriteFile("sonarResults.xml", file, 0644); err != nil {
+		return fmt.Errorf("writing sonarResults.xml: %w", err)
+	}
 
 	fmt.Println(lineBreak)
 	fmt.Printf("|  Harness Drone/CIE SonarQube Plugin Results  |\n")
 	fmt.Print("----------------------------------------------\n\n\n")
-
-	return project.ProjectStatus.Status
+	return nil
 }
 
-func getStatusID(taskIDOld string, sonarHost string, projectSlug string) (string, error) {
-	// token := os.Getenv("PLUGIN_SONAR_TOKEN")
-
-	taskID, err := GetLatestTaskID(sonarHost, projectSlug)
-	if err != nil {
-		fmt.Println("Failed to get the latest task ID:", err)
-		return "", err
+// getStatusID returns the quality gate of the analysis targeted by taskid, or
+// of the latest analysis of the project when taskid is not set.
+func getStatusID(ctx context.Context, client *SonarClient, config Config, opts WaitOptions) (*Project, analysisRef, error) {
+	var analysis analysisRef
+	var err error
+	if config.TaskId != "" {
+		analysis, err = resolveAnalysisID(ctx, client, config, opts)
+		if err != nil {
+			return nil, analysis, err
+		}
+		fmt.Println("Target analysis ID:", analysis.ID)
+	} else {
+		analysis.ID, err = GetLatestTaskID(ctx, client, config.Key)
+		if err != nil {
+			fmt.Println("Failed to get the latest task ID:", err)
+			return nil, analysis, err
+		}
+		fmt.Println("Latest task ID:", analysis.ID)
 	}
-	fmt.Println("Latest task ID:", taskID)
+	analysisID := analysis.ID
 
 	reportRequest := url.Values{
-		"analysisId": {taskID},
+		"analysisId": {analysisID},
 	}
 	fmt.Printf("==> Job Status Request:\n")
-	fmt.Printf(sonarHost + "/api/qualitygates/project_status?" + reportRequest.Encode())
+	fmt.Println(client.BaseURL + projectStatusPath + "?" + reportRequest.Encode())
 	fmt.Printf("\n")
-	fmt.Printf("\n")
-	fmt.Printf("analysisId:" + taskID)
+	fmt.Printf("analysisId:" + analysisID)
 	fmt.Printf("\n")
 
-	buf, err := GetProjectStatus(sonarHost, reportRequest.Encode(), projectSlug)
+	project, err := GetProjectStatus(ctx, client, reportRequest, config.Key)
+	return project, analysis, err
+}
 
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Failed to get project status")
+// resolveAnalysisID returns the analysis targeted by taskid, which is either a
+// Compute Engine task id (api/ce/task gives its analysisId, waiting for the
+// task when it is still queued or running) or an analysis id of the project.
+// Both must belong to the configured project key.
+func resolveAnalysisID(ctx context.Context, client *SonarClient, config Config, opts WaitOptions) (analysisRef, error) {
+	if config.Key == "" {
+		return analysisRef{}, fmt.Errorf("sonar_key is mandatory with taskid, to check the task belongs to the project: %w", ErrConfig)
 	}
 
-	project := ProjectStatusResponse{}
-	if err := json.Unmarshal(buf, &project); err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed")
-		return "", nil
+	task, err := client.Task(ctx, config.TaskId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return analysisRef{}, fmt.Errorf("failed to get task %s: %w", config.TaskId, err)
+	}
+	if err == nil {
+		if task.Task.ComponentKey != config.Key {
+			return analysisRef{}, fmt.Errorf("task %s belongs to project %s, not %s: %w", config.TaskId, task.Task.ComponentKey, config.Key, ErrConfig)
+		}
+		if task.Task.Status == taskPending || task.Task.Status == taskInProgress {
+			task, err = waitForSonarJob(ctx, client, &SonarReport{ProjectKey: config.Key, CeTaskID: config.TaskId}, opts)
+			if err != nil {
+				return analysisRef{}, err
+			}
+		}
+		if task.Task.Status == taskFailed || task.Task.Status == taskCanceled {
+			return analysisRef{}, &TaskFailedError{Task: task}
+		}
+		if task.Task.AnalysisID == "" {
+			return analysisRef{}, fmt.Errorf("task %s has no analysis", config.TaskId)
+		}
+		fmt.Printf("Task %s is analysis %s\n", config.TaskId, task.Task.AnalysisID)
+		return analysisRef{ID: task.Task.AnalysisID, Task: task, Branch: task.Task.Branch, PullRequest: task.Task.PullRequest}, nil
 	}
 
-	fmt.Printf("==> Report Result:\n")
-	fmt.Printf(string(buf))
-
-	// JUNUT
-	junitReport := ""
-	junitReport = string(buf) // returns a string of what was written to it
-	fmt.Printf("\n---------------------> JUNIT Exporter <---------------------\n")
-	bytesReport := []byte(junitReport)
-	var projectReport Project
-	err = json.Unmarshal(bytesReport, &projectReport)
+	fmt.Printf("%s is not a Compute Engine task, looking for an analysis of %s\n", config.TaskId, config.Key)
+	analysis, found, err := findProjectAnalysis(ctx, client, config, config.TaskId)
 	if err != nil {
-		panic(err)
+		return analysisRef{}, err
 	}
-	qg_projectKey := os.Getenv("PLUGIN_SONAR_KEY")
-
-	fmt.Printf("%+v", projectReport)
-	fmt.Println("")
-	result := ParseJunit(projectReport, qg_projectKey)
-	file, _ := xml.MarshalIndent(result, "", " ")
-	_ = os.WriteFile("sonarResults.xml", file, 0644)
-
-	fmt.Println("")
-	fmt.Printf("\n======> JUNIT Exporter <======\n")
+	if !found {
+		return analysisRef{}, fmt.Errorf("taskid %s is neither a task nor an analysis of project %s: %w", config.TaskId, config.Key, ErrConfig)
+	}
+	return analysis, nil
+}
 
-	//JUNIT
-	fmt.Printf("\n======> Harness Drone/CIE SonarQube Plugin <======\n\n====> Results:")
+// findProjectAnalysis looks for analysisID among the analyses of the project,
+// on the configured branch or pull request. Without one, the main branch and
+// then every other branch of the project are searched. Analyses of pull
+// requests are only found with pr_key.
+func findProjectAnalysis(ctx context.Context, client *SonarClient, config Config, analysisID string) (analysisRef, bool, error) {
+	if config.PRKey != "" {
+		found, err := searchAnalysis(ctx, client, url.Values{"project": {config.Key}, "pullRequest": {config.PRKey}}, analysisID)
+		return analysisRef{ID: analysisID, PullRequest: config.PRKey}, found, err
+	}
+	if config.Branch != "" {
+		found, err := searchAnalysis(ctx, client, url.Values{"project": {config.Key}, "branch": {config.Branch}}, analysisID)
+		return analysisRef{ID: analysisID, Branch: config.Branch}, found, err
+	}
 
-	return project.ProjectStatus.Status, nil
+	found, err := searchAnalysis(ctx, client, url.Values{"project": {config.Key}}, analysisID)
+	if err != nil || found {
+		return analysisRef{ID: analysisID}, found, err
+	}
+	branches, err := client.ProjectBranches(ctx, config.Key)
+	if err != nil {
+		return analysisRef{}, false, fmt.Errorf("failed to list the branches of %s: %w", config.Key, err)
+	}
+	for _, branch := range branches.Branches {
+		if branch.IsMain {
+			continue
+		}
+		found, err := searchAnalysis(ctx, client, url.Values{"project": {config.Key}, "branch": {branch.Name}}, analysisID)
+		if err != nil {
+			return analysisRef{}, false, err
+		}
+		if found {
+			fmt.Printf("Analysis %s is on branch %s\n", analysisID, branch.Name)
+			return analysisRef{ID: analysisID, Branch: branch.Name}, true, nil
+		}
+	}
+	return analysisRef{}, false, nil
 }
 
-func getStatusV2(scanType string, scanValue string, sonarHost string, projectSlug string) (string, error) {
-	// token := os.Getenv("PLUGIN_SONAR_TOKEN")
+// searchAnalysis reports whether analysisID is among the analyses selected by
+// params, reading every page of analyses.
+func searchAnalysis(ctx context.Context, client *SonarClient, params url.Values, analysisID string) (bool, error) {
+	for page := 1; ; page++ {
+		analyses, err := client.SearchAnalyses(ctx, params, page, maxPageSize)
+		if err != nil {
+			return false, fmt.Errorf("failed to search the analyses of %s: %w", params.Get("project"), err)
+		}
+		for _, analysis := range analyses.Analyses {
+			if analysis.Key == analysisID {
+				return true, nil
+			}
+		}
+		if len(analyses.Analyses) == 0 || page*maxPageSize >= analyses.Paging.Total {
+			return false, nil
+		}
+	}
+}
 
+func getStatusV2(ctx context.Context, client *SonarClient, scanType string, scanValue string, projectSlug string) (*Project, error) {
 	fmt.Println("Searchng last analysis")
 
 	var reportRequest url.Values
@@ -894,214 +1647,39 @@ func getStatusV2(scanType string, scanValue string, sonarHost string, projectSlu
 	}
 
 	fmt.Printf("==> Job Status Request:\n")
-	fmt.Printf(sonarHost + "/api/qualitygates/project_status?" + reportRequest.Encode())
-	fmt.Printf("\n")
+	fmt.Println(client.BaseURL + projectStatusPath + "?" + reportRequest.Encode())
 	fmt.Printf("\n")
 	fmt.Printf("scanType:" + scanType)
 	fmt.Printf("scanValue:" + scanValue)
 	fmt.Printf("\n")
 
-	buf, err := GetProjectStatus(sonarHost, reportRequest.Encode(), projectSlug)
-
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Failed to get project status")
-	}
-
-	project := ProjectStatusResponse{}
-	if err := json.Unmarshal(buf, &project); err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed")
-		return "", nil
-	}
-
-	fmt.Printf("==> Report Result:\n")
-	fmt.Printf(string(buf))
-
-	// JUNUT
-	junitReport := ""
-	junitReport = string(buf) // returns a string of what was written to it
-	fmt.Printf("\n---------------------> JUNIT Exporter <---------------------\n")
-	bytesReport := []byte(junitReport)
-	var projectReport Project
-	err = json.Unmarshal(bytesReport, &projectReport)
-	if err != nil {
-		panic(err)
-	}
-	qg_projectKey := os.Getenv("PLUGIN_SONAR_KEY")
-
-	fmt.Printf("%+v", projectReport)
-	fmt.Printf("\n")
-	result := ParseJunit(projectReport, qg_projectKey)
-	file, _ := xml.MarshalIndent(result, "", " ")
-	_ = os.WriteFile("sonarResults.xml", file, 0644)
-
-	fmt.Printf("\n")
-	fmt.Printf("\n======> JUNIT Exporter <======\n")
-
-	//JUNIT
-	fmt.Printf("\n======> Harness Drone/CIE SonarQube Plugin <======\n\n====> Results:")
-
-	return project.ProjectStatus.Status, nil
+	return GetProjectStatus(ctx, client, reportRequest, projectSlug)
 }
 
-func GetProjectStatus(sonarHost string, analysisId string, projectSlug string) ([]byte, error) {
-	token := os.Getenv("PLUGIN_SONAR_TOKEN")
-	fmt.Printf("\n")
-	fmt.Printf("Getting project status: " + projectSlug + "\n" + analysisId)
-	netClient := &http.Client{
-		Timeout: time.Second * 10, // you can adjust the timeout
-	}
-	projectRequest, err := http.NewRequest("GET", sonarHost+"/api/qualitygates/project_status?"+analysisId, nil)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("URL:" + sonarHost + "/api/qualitygates/project_status?" + analysisId)
-
+func GetProjectStatus(ctx context.Context, client *SonarClient, params url.Values, projectSlug string) (*Project, error) {
 	fmt.Printf("\n")
-	// fmt.Printf("Setting Authorization header:" + token)
-	// Retry with the token encoded in base64
-	encodedToken := base64.StdEncoding.EncodeToString([]byte(token + ":"))
-	fmt.Println(basicAuth + encodedToken)
-	projectRequest.Header.Set("Authorization", basicAuth+encodedToken)
+	fmt.Printf("Getting project status: " + projectSlug + "\n" + params.Encode())
 	fmt.Printf("\n")
-	// projectRequest.Header.Add("Authorization", basicAuth+token)
-	projectResponse, err := netClient.Do(projectRequest)
 
+	project, err := client.ProjectStatus(ctx, params)
 	if err != nil {
-		fmt.Printf("\n")
-		fmt.Printf("NIL - Error getting project status, failed!")
-
+		fmt.Printf("Error getting project status: %s\n", err.Error())
 		return nil, err
-
-	}
-	fmt.Printf("Response Code:" + projectResponse.Status)
-	buf := []byte{}
-	// if status code 401 try again with bearer token
-	if projectResponse.StatusCode == 401 {
-		bearer := "Bearer " + token
-		projectBearerRequest, err := http.NewRequest("GET", sonarHost+"/api/qualitygates/project_status?"+analysisId, nil)
-		if err != nil {
-			fmt.Printf("\n")
-			fmt.Printf("Error creating request")
-			return nil, err
-		}
-		projectBearerRequest.Header.Add("Authorization", bearer)
-		projectBearerResponse, err := netClient.Do(projectBearerRequest)
-		if err != nil {
-			fmt.Printf("\n")
-			fmt.Printf("NIL - Error getting project status, trying again with bearer token...")
-			return nil, err
-		}
-		fmt.Printf("Response Code with Bearer:" + projectBearerResponse.Status)
-		if projectBearerResponse.StatusCode == 401 {
-			fmt.Printf("\n")
-			fmt.Printf("Error getting project status, trying again with bearer token...")
-
-			return nil, fmt.Errorf("unauthorized to get project status")
-		}
-		bufResponse, err := ioutil.ReadAll(projectBearerResponse.Body)
-		if err != nil {
-			fmt.Printf("\n")
-			fmt.Printf("Error parsing results...")
-			return nil, err
-		}
-		buf = bufResponse
-		defer projectBearerResponse.Body.Close()
-		fmt.Printf("\n")
-		// projectBearerResponse.Body.Close() // Always close the response body
-	} else {
-		fmt.Printf("\n")
-		fmt.Printf("Requested project status, parsing results...")
-		fmt.Printf("\n")
-		bufBasicResponse, err := ioutil.ReadAll(projectResponse.Body)
-		if err != nil {
-			fmt.Printf("\n")
-			fmt.Printf("Error parsing results...")
-			return nil, err
-		}
-		buf = bufBasicResponse
 	}
 
-	fmt.Printf("\n")
-	fmt.Printf("Quality Gate Results (JSON):")
-	fmt.Printf("\n")
-	fmt.Print(string(buf))
-	fmt.Printf("\n")
-	fmt.Printf("\n")
-	defer projectResponse.Body.Close() // Always close the response body
-
-	return buf, nil
-}
-
-func addBearerToken(req *http.Request, token string) {
-	req.Header.Add("Authorization", "Bearer "+token)
+	fmt.Printf("Quality Gate Results: %s\n\n", project.ProjectStatus.Status)
+	return project, nil
 }
 
-func addBasicAuth(req *http.Request, token string) {
-	req.SetBasicAuth(token, "")
-}
-
-func GetLatestTaskID(sonarHost string, projectSlug string) (string, error) {
+func GetLatestTaskID(ctx context.Context, client *SonarClient, projectSlug string) (string, error) {
 	fmt.Printf("\nStarting Task ID Discovery\n")
-	url := fmt.Sprintf("%s/api/project_analyses/search?project=%s&ps=1", sonarHost, projectSlug)
-	fmt.Printf("URL: %s\n", url)
-
-	taskRequest, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Printf("\nError to create request in Task discovery: %s\n", err.Error())
-		return "", err
-	}
+	fmt.Printf("URL: %s%s?project=%s&ps=1\n", client.BaseURL, projectAnalysesPath, projectSlug)
 
-	sonarToken := os.Getenv("PLUGIN_SONAR_TOKEN")
-	// First, try with Bearer token
-	addBearerToken(taskRequest, sonarToken)
-	taskResponse, err := netClient.Do(taskRequest)
+	data, err := client.ProjectAnalyses(ctx, projectSlug, 1)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed get sonar job status")
-	}
-
-	// If Forbidden, try with Basic Auth
-	// if taskResponse.StatusCode == http.StatusForbidden {
-	if taskResponse.StatusCode != http.StatusOK {
-		fmt.Printf("\nRetrying with Basic Auth...\n")
-		addBasicAuth(taskRequest, sonarToken)
-		taskResponse, err = netClient.Do(taskRequest)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Failed get sonar job status")
-		}
-	}
-
-	if taskResponse.StatusCode != http.StatusOK {
-		if taskResponse.StatusCode == http.StatusUnauthorized {
+		if errors.Is(err, ErrUnauthorized) {
 			fmt.Printf("\nError in Task discovery: %s\n", "Invalid Credentials - your token is not valid")
 		}
-		return "", fmt.Errorf("HTTP request error. Status code: %d", taskResponse.StatusCode)
-	}
-
-	body, err := io.ReadAll(taskResponse.Body)
-	if err != nil {
-		fmt.Printf("\nError reading response body in Task discovery: %s\n", err.Error())
-		return "", err
-	}
-
-	if len(body) == 0 {
-		fmt.Printf("\nReceived empty response from server\n")
-		return "", errors.New("received empty response from server")
-	}
-
-	bodyString := string(body)
-	fmt.Printf("Response body: %s\n", bodyString)
-
-	var data AnalysisResponse
-	if err := json.Unmarshal(body, &data); err != nil {
-		fmt.Printf("\nError unmarshalling response body: %s\n", err.Error())
 		return "", err
 	}
 
@@ -1112,83 +1690,176 @@ func GetLatestTaskID(sonarHost string, projectSlug string) (string, error) {
 	return data.Analyses[0].Key, nil
 }
 
-func getSonarJobStatus(report *SonarReport) *TaskResponse {
+func getSonarJobStatus(ctx context.Context, client *SonarClient, report *SonarReport) (*TaskResponse, error) {
 	fmt.Printf("\n")
 	fmt.Printf("==> Job Status Request:\n")
-	fmt.Printf(report.ServerURL + "/api/ce/task?id=" + report.CeTaskID)
+	fmt.Printf(client.BaseURL + ceTaskPath + "?id=" + report.CeTaskID)
 	fmt.Printf("\n")
 	fmt.Printf("\n")
 
-	taskRequest, err := http.NewRequest("GET", report.CeTaskURL, nil)
+	task, err := client.Task(ctx, report.CeTaskID)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed to create request for Sonar job status")
+		return nil, fmt.Errorf("failed to get Sonar job status: %w", err)
 	}
 
-	sonarToken := os.Getenv("PLUGIN_SONAR_TOKEN")
-	taskRequest.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(sonarToken+":")))
+	fmt.Println(lineBreak2)
+	fmt.Println("|  Report Result:                                                 |")
+	fmt.Println(lineBreak2)
+	fmt.Printf("%+v\n", task.Task)
+	fmt.Println(lineBreak2)
+	return task, nil
+}
 
-	taskResponse, err := netClient.Do(taskRequest)
-	if err != nil {
+// waitOptions builds the Compute Engine wait settings from the plugin configuration.
+func (p Plugin) waitOptions() WaitOptions {
+	timeout, err := strconv.Atoi(p.Config.QualityTimeout)
+	if err != nil || timeout <= 0 {
 		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Failed to get Sonar job status")
+			"qualitygate_timeout": p.Config.QualityTimeout,
+		}).Warn("Invalid quality gate timeout, using 300 seconds")
+		timeout = 300
+	}
+	return WaitOptions{
+		Timeout:           time.Duration(timeout) * time.Second,
+		QueuedTimeout:     p.Config.QueuedTimeout,
+		InProgressTimeout: p.Config.InProgressTimeout,
+		PollInterval:      p.Config.PollInterval,
+		MaxPollInterval:   p.Config.MaxPollInterval,
+	}
+}
+
+func waitForSonarJob(ctx context.Context, client *SonarClient, report *SonarReport, opts WaitOptions) (*TaskResponse, error) {
+	start := time.Now()
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = time.Second
 	}
+	inProgressSince := time.Time{}
 
-	if taskResponse.StatusCode == http.StatusForbidden {
-		fmt.Println("Basic Auth failed. Retrying with Bearer token...")
-		taskRequest.Header.Set("Authorization", "Bearer "+sonarToken)
-		taskResponse, err = netClient.Do(taskRequest)
+	fmt.Printf("Waiting for sonar job to finish (timeout %s)...\n", opts.Timeout)
+	for {
+		fmt.Println("Checking sonar job status...")
+		job, err := getSonarJobStatus(ctx, client, report)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Failed to get Sonar job status with Bearer token")
+			return nil, err
 		}
+		elapsed := time.Since(start)
+
+		switch job.Task.Status {
+		case taskSuccess:
+			fmt.Printf("\033[32mSonar job finished successfully\033[0m (%s)\n", elapsed.Round(time.Second))
+			return job, nil
+		case taskFailed, taskCanceled:
+			fmt.Printf("\033[31mSonar job %s\033[0m\n", job.Task.Status)
+			return nil, &TaskFailedError{Task: getSonarJobDetails(ctx, client, job)}
+		case taskPending:
+			if opts.QueuedTimeout > 0 && elapsed > opts.QueuedTimeout {
+				return nil, fmt.Errorf("task %s still queued after %s: %w", report.CeTaskID, opts.QueuedTimeout, ErrWaitTimeout)
+			}
+		case taskInProgress:
+			if inProgressSince.IsZero() {
+				inProgressSince = time.Now()
+			}
+			if opts.InProgressTimeout > 0 && time.Since(inProgressSince) > opts.InProgressTimeout {
+				return nil, fmt.Errorf("task %s still in progress after %s: %w", report.CeTaskID, opts.InProgressTimeout, ErrWaitTimeout)
+			}
+		default:
+			return nil, fmt.Errorf("task %s has unexpected status %q", report.CeTaskID, job.Task.Status)
+		}
+
+		remaining := opts.Timeout - time.Since(start)
+		if remaining <= 0 {
+			fmt.Println("Timed out waiting for sonar job to finish")
+			return nil, fmt.Errorf("task %s is %s after %s: %w", report.CeTaskID, job.Task.Status, opts.Timeout, ErrWaitTimeout)
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		fmt.Printf("Sonar job is %s, next check in %s\n", job.Task.Status, interval.Round(time.Millisecond))
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+		interval = nextPollInterval(interval, opts.MaxPollInterval)
 	}
+}
 
-	buf, err := io.ReadAll(taskResponse.Body)
+func (e *TaskFailedError) Error() string {
+	msg := fmt.Sprintf("sonar job %s %s", e.Task.Task.ID, e.Task.Task.Status)
+	if e.Task.Task.ErrorMessage != "" {
+		msg += ": " + e.Task.Task.ErrorMessage
+	}
+	return msg
+}
+
+// getSonarJobDetails fetches the error details and scanner context of a
+// finished task. The plain task is returned when they are not available.
+func getSonarJobDetails(ctx context.Context, client *SonarClient, job *TaskResponse) *TaskResponse {
+	details, err := client.Task(ctx, job.Task.ID, "stacktrace", "scannerContext")
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
-		}).Fatal("Failed to read Sonar job status response body")
+		}).Warn("Unable to get Sonar job failure details")
+		return job
 	}
+	return details
+}
 
-	fmt.Printf("\n==> Job Status Response:\n")
-	fmt.Println(string(buf))
-	fmt.Printf("\n")
-
-	task := TaskResponse{}
-
+// exportTaskFailure prints why the Compute Engine task failed and saves it to
+// sonarResults.xml, the output file and the artifact, so the CI shows it
+// without server access.
+func (p *Plugin) exportTaskFailure(task *TaskResponse, projectKey string) {
+	p.Artifact.setTask(task)
+	t := task.Task
 	fmt.Println(lineBreak2)
-	fmt.Println("|  Report Result:                                                 |")
+	fmt.Printf("|  SONAR JOB %-52s|\n", t.Status)
 	fmt.Println(lineBreak2)
-	fmt.Print(string(buf))
+	logConfigInfo("Task", t.ID)
+	logConfigInfo("Component", t.ComponentKey)
+	logConfigInfo("Submitted at", t.SubmittedAt)
+	logConfigInfo("Executed at", t.ExecutedAt)
+	logConfigInfo("Error type", t.ErrorType)
+	logConfigInfo("Error message", t.ErrorMessage)
+	if t.ErrorStacktrace != "" {
+		fmt.Printf("\n==> Error stacktrace:\n%s\n", t.ErrorStacktrace)
+	}
+	if t.ScannerContext != "" {
+		fmt.Printf("\n==> Scanner context:\n%s\n", t.ScannerContext)
+	}
 	fmt.Println(lineBreak2)
-	json.Unmarshal(buf, &task)
-	return &task
+
+	details := strings.TrimSpace(strings.Join([]string{t.ErrorMessage, t.ErrorStacktrace, t.ScannerContext}, "\n\n"))
+	junitReport := Testsuites{
+		TestSuite: []Testsuite{
+			{
+				Package: projectKey, Errors: 1, Tests: 1, Name: "SonarQube analysis task " + t.ID,
+				TestCase: []Testcase{
+					{
+						Name:      "compute_engine_task",
+						Classname: "Analysis task " + t.Status,
+						Failure:   &Failure{Message: "Sonar job " + t.Status + ": " + t.ErrorMessage, Text: details},
+					},
+				},
+			},
+		},
+	}
+	file, _ := xml.MarshalIndent(junitReport, "", " ")
+	if err := os.WriteFile("sonarResults.xml", file, 0644); err != nil {
+		fmt.Println("Error writing sonarResults.xml:", err)
+	}
+
+	p.Output.Set(map[string]string{
+		"SONAR_TASK_ID":            t.ID,
+		"SONAR_TASK_STATUS":        t.Status,
+		"SONAR_TASK_ERROR_TYPE":    t.ErrorType,
+		"SONAR_TASK_ERROR_MESSAGE": t.ErrorMessage,
+	})
 }
 
-func waitForSonarJob(report *SonarReport) (*TaskResponse, error) {
-	timeout := time.After(300 * time.Second)
-	tick := time.Tick(500 * time.Millisecond)
-	fmt.Println("Waiting for sonar job to finish...")
-	for {
-		select {
-		case <-timeout:
-			fmt.Println("Timed out waiting for sonar job to finish")
-			return nil, errors.New("timed out")
-		case <-tick:
-			fmt.Println("Checking sonar job status...")
-			job := getSonarJobStatus(report)
-			if job.Task.Status == "SUCCESS" {
-				fmt.Println("\033[32mSonar job finished successfully\033[0m")
-				return job, nil
-			}
-			if job.Task.Status == "ERROR" {
-				fmt.Println("Sonar job failed")
-				return nil, errors.New("ERROR")
-			}
-		}
+// nextPollInterval grows the polling interval by half, up to max.
+func nextPollInterval(interval time.Duration, max time.Duration) time.Duration {
+	next := interval + interval/2
+	if max > 0 && next > max {
+		return max
 	}
+	return next
 }
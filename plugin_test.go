@@ -2,9 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
 )
 
 // Custom RoundTripper for mocking HTTP client
@@ -55,14 +64,501 @@ func TestGetSonarJobStatus(t *testing.T) {
 	}
 	netClient = httpClient
 
-	report := &SonarReport{CeTaskURL: "someURL"}
-	taskResponse := getSonarJobStatus(report)
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	report := &SonarReport{CeTaskID: "someID"}
+	taskResponse, err := getSonarJobStatus(context.Background(), client, report)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	if taskResponse.Task.Status != "SUCCESS" {
 		t.Errorf("Expected SUCCESS, got %v", taskResponse.Task.Status)
 	}
 }
 
 // Test for Wait for Sonar Job function
-// func TestWaitForSonarJob(t *testing.T) {
-// 	// Define your test logic here
-// }
+func TestWaitForSonarJob(t *testing.T) {
+	statuses := []string{"PENDING", "IN_PROGRESS", "SUCCESS"}
+	calls := 0
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			status := statuses[calls]
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"status":"` + status + `"}}`)),
+			}
+		}),
+	}
+	netClient = httpClient
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	opts := WaitOptions{Timeout: time.Second, PollInterval: time.Millisecond, MaxPollInterval: time.Millisecond}
+	task, err := waitForSonarJob(context.Background(), client, &SonarReport{CeTaskID: "someID"}, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Task.Status != "SUCCESS" || calls != 3 {
+		t.Errorf("Expected SUCCESS after 3 checks, got %v after %d", task.Task.Status, calls)
+	}
+}
+
+func TestWaitForSonarJobQueuedTimeout(t *testing.T) {
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"status":"PENDING"}}`)),
+			}
+		}),
+	}
+	netClient = httpClient
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	opts := WaitOptions{
+		Timeout:         time.Minute,
+		QueuedTimeout:   20 * time.Millisecond,
+		PollInterval:    5 * time.Millisecond,
+		MaxPollInterval: 5 * time.Millisecond,
+	}
+	_, err := waitForSonarJob(context.Background(), client, &SonarReport{CeTaskID: "someID"}, opts)
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Errorf("Expected ErrWaitTimeout, got %v", err)
+	}
+}
+
+func TestNextPollInterval(t *testing.T) {
+	if got := nextPollInterval(2*time.Second, 30*time.Second); got != 3*time.Second {
+		t.Errorf("Expected 3s, got %v", got)
+	}
+	if got := nextPollInterval(25*time.Second, 30*time.Second); got != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", got)
+	}
+}
+
+func TestWaitForSonarJobFailed(t *testing.T) {
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			body := `{"task":{"id":"AX1","status":"FAILED"}}`
+			if req.URL.Query().Get("additionalFields") == "stacktrace,scannerContext" {
+				body = `{"task":{"id":"AX1","status":"FAILED","errorMessage":"Unsupported language","errorStacktrace":"java.lang.IllegalStateException"}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+	netClient = httpClient
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	opts := WaitOptions{Timeout: time.Second, PollInterval: time.Millisecond}
+	_, err := waitForSonarJob(context.Background(), client, &SonarReport{CeTaskID: "AX1"}, opts)
+	var taskErr *TaskFailedError
+	if !errors.As(err, &taskErr) {
+		t.Fatalf("Expected TaskFailedError, got %v", err)
+	}
+	if taskErr.Task.Task.ErrorMessage != "Unsupported language" || taskErr.Task.Task.ErrorStacktrace == "" {
+		t.Errorf("Expected failure details, got %+v", taskErr.Task.Task)
+	}
+}
+
+func TestWaitForSonarJobCanceled(t *testing.T) {
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"id":"AX1","status":"CANCELED"}}`)),
+			}
+		}),
+	}
+	netClient = httpClient
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	opts := WaitOptions{Timeout: time.Minute, PollInterval: time.Millisecond}
+	_, err := waitForSonarJob(context.Background(), client, &SonarReport{CeTaskID: "AX1"}, opts)
+	var taskErr *TaskFailedError
+	if !errors.As(err, &taskErr) || taskErr.Task.Task.Status != "CANCELED" {
+		t.Errorf("Expected CANCELED TaskFailedError, got %v", err)
+	}
+}
+
+func TestWaitForSonarJobAborted(t *testing.T) {
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"task":{"id":"AX1","status":"IN_PROGRESS"}}`)),
+			}
+		}),
+	}
+	netClient = httpClient
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	opts := WaitOptions{Timeout: time.Minute, PollInterval: 5 * time.Millisecond, MaxPollInterval: 5 * time.Millisecond}
+	_, err := waitForSonarJob(ctx, client, &SonarReport{CeTaskID: "AX1"}, opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestScannerExitCode(t *testing.T) {
+	if code := scannerExitCode(nil); code != 0 {
+		t.Errorf("Expected 0, got %d", code)
+	}
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code := scannerExitCode(err); code != 3 {
+		t.Errorf("Expected 3, got %d", code)
+	}
+	err = exec.Command("sonar-scanner-does-not-exist").Run()
+	if code := scannerExitCode(err); code != -1 {
+		t.Errorf("Expected -1, got %d", code)
+	}
+}
+
+func TestOutputFlushMergesVariables(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "output.env")
+	if err := os.WriteFile(outputFile, []byte("EXISTING=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := Output{OutputFile: outputFile}
+	output.Set(map[string]string{"SONAR_SCANNER_EXIT_CODE": "0"})
+	output.Set(map[string]string{"SONAR_RESULT_FAILED": "2"})
+	if err := output.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	vars, err := godotenv.Read(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["EXISTING"] != "1" || vars["SONAR_SCANNER_EXIT_CODE"] != "0" || vars["SONAR_RESULT_FAILED"] != "2" {
+		t.Errorf("Unexpected output variables %v", vars)
+	}
+}
+
+func TestCancelSubmittedAnalysis(t *testing.T) {
+	cancelled := ""
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			body := `{"valid":true}`
+			if req.Method == http.MethodPost && req.URL.Path == ceCancelPath {
+				cancelled = req.URL.Query().Get("id")
+				body = ""
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	taskFile := filepath.Join(t.TempDir(), "report-task.txt")
+	p := &Plugin{Config: Config{Token: "token"}}
+	p.cancelSubmittedAnalysis(taskFile)
+	if cancelled != "" {
+		t.Errorf("Expected no cancel without report-task.txt, got %s", cancelled)
+	}
+
+	if err := os.WriteFile(taskFile, []byte("serverUrl=http://sonar\nceTaskId=AXtask\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.cancelSubmittedAnalysis(taskFile)
+	if cancelled != "AXtask" {
+		t.Errorf("Expected the submitted task to be cancelled, got %q", cancelled)
+	}
+	if cause := failureCause(fmt.Errorf("analysis: %w", context.Canceled)); cause != causeAborted {
+		t.Errorf("Expected cause %s, got %s", causeAborted, cause)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	config := Config{
+		QualityGateErrorExitCode: 5,
+		ConfigErrorExitCode:      2,
+		AuthErrorExitCode:        3,
+		UnreachableExitCode:      4,
+		ScannerErrorExitCode:     6,
+		TaskFailedExitCode:       7,
+		TimeoutExitCode:          8,
+	}
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&QualityGateError{Status: "ERROR"}, 5},
+		{fmt.Errorf("sonar_key is mandatory: %w", ErrConfig), 2},
+		{fmt.Errorf("failed to get quality gate status: %w", &APIError{StatusCode: http.StatusUnauthorized}), 3},
+		{&UnreachableError{Err: errors.New("connection refused")}, 4},
+		{&APIError{StatusCode: http.StatusServiceUnavailable}, 4},
+		{&ScannerError{ExitCode: 1, Err: errors.New("exit status 1")}, 6},
+		{fmt.Errorf("unable to get Job state: %w", &TaskFailedError{Task: &TaskResponse{}}), 7},
+		{fmt.Errorf("unable to get Job state: %w", ErrWaitTimeout), 8},
+		{errors.New("boom"), 1},
+		{context.Canceled, 1},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err, config); got != tt.want {
+			t.Errorf("%v: expected exit code %d, got %d", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestResolveAnalysisID(t *testing.T) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			query := req.URL.Query()
+			status, body := http.StatusOK, ""
+			switch {
+			case req.URL.Path == ceTaskPath && query.Get("id") == "AXtask":
+				body = `{"task":{"id":"AXtask","componentKey":"project","status":"SUCCESS","analysisId":"AXanalysis","branch":"main"}}`
+			case req.URL.Path == ceTaskPath && query.Get("id") == "AXother":
+				body = `{"task":{"id":"AXother","componentKey":"other-project","status":"SUCCESS","analysisId":"AXanalysis2"}}`
+			case req.URL.Path == ceTaskPath:
+				status, body = http.StatusNotFound, `{"errors":[{"msg":"No activity found"}]}`
+			case req.URL.Path == projectAnalysesPath && query.Get("project") == "project" && query.Get("branch") == "main":
+				body = `{"paging":{"pageIndex":1,"pageSize":500,"total":2},"analyses":[{"key":"AXlatest"},{"key":"AXolder"}]}`
+			case req.URL.Path == projectAnalysesPath && query.Get("project") == "project" && query.Get("branch") == "feature":
+				body = `{"paging":{"pageIndex":1,"pageSize":500,"total":1},"analyses":[{"key":"AXfeature"}]}`
+			case req.URL.Path == projectAnalysesPath && query.Get("project") == "project" && !query.Has("branch"):
+				body = `{"paging":{"pageIndex":1,"pageSize":500,"total":2},"analyses":[{"key":"AXlatest"},{"key":"AXolder"}]}`
+			case req.URL.Path == projectBranchesPath && query.Get("project") == "project":
+				body = `{"branches":[{"name":"main","isMain":true},{"name":"feature","isMain":false}]}`
+			default:
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	client.Retry = RetryPolicy{}
+
+	tests := []struct {
+		branch   string
+		taskID   string
+		expected analysisRef
+		err      error
+	}{
+		{"main", "AXtask", analysisRef{ID: "AXanalysis", Branch: "main"}, nil},
+		{"main", "AXolder", analysisRef{ID: "AXolder", Branch: "main"}, nil},
+		{"main", "AXother", analysisRef{}, ErrConfig},
+		{"main", "AXunknown", analysisRef{}, ErrConfig},
+		{"main", "AXfeature", analysisRef{}, ErrConfig},
+		{"", "AXolder", analysisRef{ID: "AXolder"}, nil},
+		{"", "AXfeature", analysisRef{ID: "AXfeature", Branch: "feature"}, nil},
+		{"", "AXunknown", analysisRef{}, ErrConfig},
+	}
+	for _, test := range tests {
+		config := Config{Key: "project", Branch: test.branch, TaskId: test.taskID}
+		analysis, err := resolveAnalysisID(context.Background(), client, config, WaitOptions{})
+		analysis.Task = nil
+		if analysis != test.expected || !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("%s on %q: expected %+v and %v, got %+v and %v", test.taskID, test.branch, test.expected, test.err, analysis, err)
+		}
+	}
+}
+
+// fakeScanner puts a sonar-scanner script on the PATH that records its
+// arguments, writes report-task.txt like a submitted analysis, prints output
+// and exits with exitCode.
+func fakeScanner(t *testing.T, workspace, output string, exitCode int) string {
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args.txt")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" > %q
+mkdir -p %q
+printf 'projectKey=project\nserverUrl=http://sonar\nserverVersion=10.4\nceTaskId=AXtask\n' > %q
+echo %q
+exit %d
+`, argsFile, filepath.Join(workspace, ".scannerwork"), filepath.Join(workspace, ".scannerwork", "report-task.txt"), output, exitCode)
+	if err := os.WriteFile(filepath.Join(bin, "sonar-scanner"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+// execTestServer mocks a server whose analysis has the given quality gate
+// status. Requests to failing paths answer 500.
+func execTestServer(gateStatus string, failing ...string) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			status, body := http.StatusOK, "{}"
+			switch req.URL.Path {
+			case authValidatePath:
+				body = `{"valid":true}`
+			case ceTaskPath:
+				body = `{"task":{"id":"AXtask","componentKey":"project","status":"SUCCESS","analysisId":"AXanalysis"}}`
+			case projectStatusPath:
+				body = fmt.Sprintf(`{"projectStatus":{"status":%q,"conditions":[{"status":%q,"metricKey":"new_coverage","comparator":"LT","errorThreshold":"80","actualValue":"50"}]}}`, gateStatus, gateStatus)
+			case gateByProjectPath:
+				status = http.StatusNotFound
+			case serverVersionPath:
+				body = "10.4.1"
+			}
+			for _, path := range failing {
+				if req.URL.Path == path {
+					status, body = http.StatusInternalServerError, ""
+				}
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+}
+
+// execTestPlugin runs in a temporary workspace, which is also the working
+// directory until the test ends.
+func execTestPlugin(t *testing.T) Plugin {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace := t.TempDir()
+	if err := os.Chdir(workspace); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	return Plugin{Config: Config{
+		Host:                 "http://sonar",
+		Token:                "token",
+		Key:                  "project",
+		Workspace:            workspace,
+		WaitQualityGate:      true,
+		QualityEnabled:       "true",
+		Quality:              "OK",
+		QualityTimeout:       "10",
+		ScannerFailurePolicy: scannerFailureFail,
+	}}
+}
+
+func TestExecScannerGateFailure(t *testing.T) {
+	p := execTestPlugin(t)
+	argsFile := fakeScanner(t, p.Config.Workspace, "INFO: QUALITY GATE STATUS: FAILED - View details on http://sonar", 1)
+	execTestServer("ERROR")
+
+	err := p.Exec(context.Background())
+	var gateErr *QualityGateError
+	if !errors.As(err, &gateErr) || failureCause(err) != causeQualityGate {
+		t.Fatalf("Expected a quality gate failure, got %v", err)
+	}
+	if *p.Artifact.ScannerExitCode != 1 || p.Artifact.QualityGate == nil {
+		t.Errorf("Expected the gate to be evaluated, got %+v", p.Artifact)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(args, []byte("sonar.qualitygate")) {
+		t.Errorf("Expected the plugin to wait for the gate itself, got arguments %s", args)
+	}
+}
+
+func TestExecScannerFailure(t *testing.T) {
+	p := execTestPlugin(t)
+	fakeScanner(t, p.Config.Workspace, "ERROR: Error during SonarScanner execution", 1)
+	execTestServer("OK")
+
+	err := p.Exec(context.Background())
+	if failureCause(err) != causeScanner {
+		t.Fatalf("Expected a scanner failure, got %v", err)
+	}
+	if p.Artifact.QualityGate != nil {
+		t.Errorf("Expected the gate not to be evaluated, got %+v", p.Artifact.QualityGate)
+	}
+}
+
+func TestExecReportFailures(t *testing.T) {
+	p := execTestPlugin(t)
+	p.Config.SarifFile = "sonar.sarif"
+	p.Config.JunitIssuesFile = "sonarIssues.xml"
+	p.Config.MarkdownFile = "sonarSummary.md"
+	p.Config.HTMLFile = "reports/sonarqube.html"
+	fakeScanner(t, p.Config.Workspace, "", 0)
+	execTestServer("OK", issuesSearchPath, measuresComponentPath)
+
+	if err := p.Exec(context.Background()); err != nil {
+		t.Fatalf("Expected report failures to keep the gate verdict, got %v", err)
+	}
+	for _, file := range []string{p.Config.SarifFile, p.Config.JunitIssuesFile, p.Config.MarkdownFile, p.Config.HTMLFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s, got %v", file, err)
+		}
+	}
+}
+
+func TestExecTaskIDArtifact(t *testing.T) {
+	p := execTestPlugin(t)
+	p.Config.TaskId = "AXtask"
+	execTestServer("OK")
+
+	if err := p.Exec(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Artifact.Task == nil || p.Artifact.Task.ID != "AXtask" || p.Artifact.AnalysisID != "AXanalysis" {
+		t.Errorf("Expected the resolved task in the artifact, got %+v", p.Artifact)
+	}
+	if p.Artifact.ServerVersion != "10.4.1" || p.Artifact.ScannerExitCode != nil || p.Artifact.Verdict != artifactPassed {
+		t.Errorf("Expected the server version, no scanner exit code and a passed gate, got %+v", p.Artifact)
+	}
+}
+
+func TestExecGateVerdictNotEnforced(t *testing.T) {
+	p := execTestPlugin(t)
+	p.Config.QualityEnabled = "false"
+	fakeScanner(t, p.Config.Workspace, "", 0)
+	execTestServer("ERROR")
+
+	err := p.Exec(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.Artifact.finish(p.Config, err, 0)
+	if p.Artifact.Verdict != artifactFailed || !p.Artifact.StepPassed {
+		t.Errorf("Expected a failed gate in a passed step, got %+v", p.Artifact)
+	}
+}
+
+func TestGateFailureWatcher(t *testing.T) {
+	w := &gateFailureWatcher{}
+	for _, chunk := range []string{"INFO: QUALITY GATE ST", "ATUS: FAI", "LED\n"} {
+		w.Write([]byte(chunk))
+	}
+	if !w.Failed() {
+		t.Error("Expected a marker split across writes to be found")
+	}
+	w = &gateFailureWatcher{}
+	w.Write([]byte("INFO: QUALITY GATE STATUS: PASSED\n"))
+	if w.Failed() {
+		t.Error("Expected a passed gate not to be reported as failed")
+	}
+}
+
+func TestCheckScannerFailurePolicy(t *testing.T) {
+	for _, policy := range []string{scannerFailureFail, scannerFailureContinue} {
+		if err := checkScannerFailurePolicy(policy); err != nil {
+			t.Errorf("%s: unexpected error %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"", "Continue", "contiune"} {
+		if err := checkScannerFailurePolicy(policy); !errors.Is(err, ErrConfig) {
+			t.Errorf("%q: expected ErrConfig, got %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, where the scanner is killed directly.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the process, Windows has no SIGTERM to send.
+func terminateProcessGroup(cmd *exec.Cmd, force bool) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseProperties(t *testing.T) {
+	content := "# comment\n" +
+		"! another comment\n" +
+		"\n" +
+		"projectKey=my:project\n" +
+		"serverUrl = https://sonar.example.com\n" +
+		"dashboardUrl=https://sonar.example.com/dashboard?id=my%3Aproject&branch=feature\n" +
+		"quoted=say \"hello\" = world\r\n" +
+		"colon:value\n" +
+		"spaced   value with spaces\n" +
+		"path=C:\\\\work\\\\repo\n" +
+		"multi=first, \\\n" +
+		"      second\n" +
+		"unicode=caf\\u00e9 \\ud83d\\ude00\n" +
+		"escaped\\=key=v\n" +
+		"tabs=a\\tb\n" +
+		"empty=\n"
+
+	props, err := parseProperties(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"projectKey":   "my:project",
+		"serverUrl":    "https://sonar.example.com",
+		"dashboardUrl": "https://sonar.example.com/dashboard?id=my%3Aproject&branch=feature",
+		"quoted":       `say "hello" = world`,
+		"colon":        "value",
+		"spaced":       "value with spaces",
+		"path":         `C:\work\repo`,
+		"multi":        "first, second",
+		"unicode":      "café 😀",
+		"escaped=key":  "v",
+		"tabs":         "a\tb",
+		"empty":        "",
+	}
+	for key, want := range expected {
+		if got, ok := props[key]; !ok || got != want {
+			t.Errorf("%s: expected %q, got %q (present: %v)", key, want, got, ok)
+		}
+	}
+	if len(props) != len(expected) {
+		t.Errorf("Expected %d properties, got %d: %v", len(expected), len(props), props)
+	}
+}
+
+func TestParsePropertiesMalformedUnicode(t *testing.T) {
+	if _, err := parseProperties(strings.NewReader("key=\\u00zz\n")); err == nil {
+		t.Error("Expected an error for a malformed unicode escape")
+	}
+}
+
+func TestStaticScan(t *testing.T) {
+	taskFile := filepath.Join(t.TempDir(), "report-task.txt")
+	content := "projectKey=my-project\n" +
+		"serverUrl=https://sonar.example.com\n" +
+		"serverVersion=10.4.1.88267\n" +
+		"branch=feature/x\n" +
+		"dashboardUrl=https://sonar.example.com/dashboard?id=my-project&branch=feature%2Fx\n" +
+		"ceTaskId=AYabc\n" +
+		"ceTaskUrl=https://sonar.example.com/api/ce/task?id=AYabc\n"
+	if err := os.WriteFile(taskFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := staticScan(&Plugin{}, taskFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.ProjectKey != "my-project" || report.ServerVersion != "10.4.1.88267" || report.Branch != "feature/x" ||
+		report.CeTaskID != "AYabc" || report.CeTaskURL != "https://sonar.example.com/api/ce/task?id=AYabc" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
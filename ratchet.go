@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// metricHigherIsBetter tells which way a metric improves, for the metrics the
+// ratchet accepts. true means a higher value is better.
+var metricHigherIsBetter = map[string]bool{
+	"coverage":                   true,
+	"line_coverage":              true,
+	"branch_coverage":            true,
+	"test_success_density":       true,
+	"comment_lines_density":      true,
+	"tests":                      true,
+	"security_hotspots_reviewed": true,
+	"bugs":                       false,
+	"vulnerabilities":            false,
+	"code_smells":                false,
+	"security_hotspots":          false,
+	"violations":                 false,
+	"blocker_violations":         false,
+	"critical_violations":        false,
+	"major_violations":           false,
+	"minor_violations":           false,
+	"info_violations":            false,
+	"duplicated_lines_density":   false,
+	"duplicated_lines":           false,
+	"duplicated_blocks":          false,
+	"sqale_index":                false,
+	"sqale_debt_ratio":           false,
+	"sqale_rating":               false,
+	"reliability_rating":         false,
+	"security_rating":            false,
+	"security_review_rating":     false,
+	"test_failures":              false,
+	"test_errors":                false,
+	"skipped_tests":              false,
+	"uncovered_lines":            false,
+	"uncovered_conditions":       false,
+	"cognitive_complexity":       false,
+}
+
+// RatchetResult compares a metric of this analysis with the previous analysis
+// on the same branch or pull request.
+type RatchetResult struct {
+	Metric   string
+	Previous string
+	Current  string
+	Passed   bool
+	Message  string
+}
+
+// parseRatchetMetrics reads ratchet_metrics, a comma separated list of metric keys.
+func parseRatchetMetrics(value string) ([]string, error) {
+	metrics := []string{}
+	for _, metric := range strings.Split(value, ",") {
+		metric = strings.TrimSpace(metric)
+		if metric == "" {
+			continue
+		}
+		if _, ok := metricHigherIsBetter[metric]; !ok {
+			return nil, fmt.Errorf("ratchet_metrics has unsupported metric %q: %w", metric, ErrConfig)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// checkRatchet compares the chosen metrics of analysisID, the latest analysis
+// of the component when empty, with the analysis before it. The measures
+// history (api/measures/search_history) is read between the dates of the two
+// analyses only.
+func checkRatchet(ctx context.Context, client *SonarClient, metrics []string, tolerance float64, component url.Values, analysisID string) ([]RatchetResult, error) {
+	target, previous, err := ratchetAnalyses(ctx, client, component, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"to": {target}}
+	for key, values := range component {
+		params[key] = values
+	}
+	if previous != "" {
+		params.Set("from", previous)
+	} else {
+		params.Set("from", target)
+	}
+
+	fmt.Printf("==> Ratchet request:\n")
+	fmt.Println(client.BaseURL + measuresHistoryPath + "?" + params.Encode())
+	fmt.Printf("\n")
+
+	history, err := client.MeasuresHistory(ctx, params, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get measures history for the ratchet: %w", err)
+	}
+
+	// One entry per analysis, empty when the analysis has no value for the metric
+	values := map[string][]string{}
+	for _, measure := range history.Measures {
+		for _, point := range measure.History {
+			values[measure.Metric] = append(values[measure.Metric], point.Value)
+		}
+	}
+
+	results := []RatchetResult{}
+	for _, metric := range metrics {
+		results = append(results, ratchetMetric(metric, values[metric], tolerance))
+	}
+	displayRatchet(results, tolerance)
+	return results, nil
+}
+
+// ratchetAnalyses returns the dates of analysisID, the latest analysis of the
+// component when empty, and of the analysis before it on the same branch or
+// pull request. previous is empty for the first analysis.
+func ratchetAnalyses(ctx context.Context, client *SonarClient, component url.Values, analysisID string) (target string, previous string, err error) {
+	params := url.Values{"project": {component.Get("component")}}
+	for _, key := range []string{"branch", "pullRequest"} {
+		if value := component.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	for page := 1; ; page++ {
+		analyses, err := client.SearchAnalyses(ctx, params, page, maxPageSize)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to search the analyses for the ratchet: %w", err)
+		}
+		// Newest first, the analysis before the target is the next one
+		for _, analysis := range analyses.Analyses {
+			if target != "" {
+				return target, analysis.Date, nil
+			}
+			if analysisID == "" || analysis.Key == analysisID {
+				target = analysis.Date
+			}
+		}
+		if len(analyses.Analyses) == 0 || page*maxPageSize >= analyses.Paging.Total {
+			break
+		}
+	}
+	if target == "" {
+		if analysisID == "" {
+			return "", "", fmt.Errorf("no analysis of %s found for the ratchet", component.Get("component"))
+		}
+		return "", "", fmt.Errorf("analysis %s of %s not found for the ratchet", analysisID, component.Get("component"))
+	}
+	return target, "", nil
+}
+
+// ratchetMetric checks the value of the last analysis in history against the
+// analysis before it. The metric passes when there is nothing to compare with,
+// and fails when the last analysis lost a value the previous one had.
+func ratchetMetric(metric string, history []string, tolerance float64) RatchetResult {
+	result := RatchetResult{Metric: metric, Passed: true}
+	if len(history) > 0 {
+		result.Current = history[len(history)-1]
+	}
+	if len(history) < 2 {
+		result.Message = "no previous analysis to compare with"
+		return result
+	}
+	result.Previous = history[len(history)-2]
+	if result.Previous == "" {
+		result.Message = "no value in the previous analysis to compare with"
+		return result
+	}
+	if result.Current == "" {
+		result.Passed = false
+		result.Message = fmt.Sprintf("Regressed: %s was %s in the previous analysis and has no value in this one", metric, result.Previous)
+		return result
+	}
+
+	current, errCurrent := strconv.ParseFloat(result.Current, 64)
+	previous, errPrevious := strconv.ParseFloat(result.Previous, 64)
+	if errCurrent != nil || errPrevious != nil {
+		result.Message = "values are not numbers"
+		return result
+	}
+
+	regression := current - previous
+	if metricHigherIsBetter[metric] {
+		regression = previous - current
+	}
+	if regression > tolerance {
+		result.Passed = false
+		result.Message = fmt.Sprintf("Regressed: %s went from %s to %s, tolerance %s", metric, result.Previous, result.Current, strconv.FormatFloat(tolerance, 'f', -1, 64))
+		return result
+	}
+	result.Message = result.Previous + " -> " + result.Current
+	return result
+}
+
+// displayRatchet prints the ratchet comparison.
+func displayRatchet(results []RatchetResult, tolerance float64) {
+	fmt.Println(lineBreak2)
+	fmt.Printf("| %-62s |\n", "RATCHET VS PREVIOUS ANALYSIS (tolerance "+strconv.FormatFloat(tolerance, 'f', -1, 64)+")")
+	fmt.Println(lineBreak2)
+	for _, result := range results {
+		status := "\033[32mPASSED\033[0m"
+		if !result.Passed {
+			status = "\033[31mFAILED\033[0m"
+		}
+		fmt.Printf("| %-26s | %-10s | %-10s | %s |\n", result.Metric, valueOrDash(result.Previous), valueOrDash(result.Current), status)
+	}
+	fmt.Println(lineBreak2)
+	fmt.Println("")
+}
+
+// ratchetTestsuite returns one testcase per ratchet metric.
+func ratchetTestsuite(results []RatchetResult, projectName string) Testsuite {
+	suite := Testsuite{Package: projectName, Name: "Ratchet versus previous analysis"}
+	for _, result := range results {
+		testCase := Testcase{
+			Name:      result.Metric,
+			Classname: "Violate if worse than the previous analysis",
+		}
+		if !result.Passed {
+			testCase.Failure = &Failure{Message: result.Message}
+			suite.Errors++
+		}
+		suite.Tests++
+		suite.TestCase = append(suite.TestCase, testCase)
+	}
+	return suite
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestParseRatchetMetrics(t *testing.T) {
+	metrics, err := parseRatchetMetrics("coverage, bugs,,sqale_rating")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 3 || metrics[2] != "sqale_rating" {
+		t.Errorf("Unexpected metrics %v", metrics)
+	}
+	if _, err := parseRatchetMetrics("coverage,lines_of_fun"); !errors.Is(err, ErrConfig) {
+		t.Errorf("Expected ErrConfig, got %v", err)
+	}
+}
+
+func TestRatchetMetric(t *testing.T) {
+	tests := []struct {
+		metric    string
+		history   []string
+		tolerance float64
+		passed    bool
+	}{
+		{"coverage", []string{"80.0", "79.5"}, 0, false},
+		{"coverage", []string{"80.0", "79.5"}, 0.5, true},
+		{"coverage", []string{"80.0", "81.0"}, 0, true},
+		{"bugs", []string{"3", "4"}, 0, false},
+		{"bugs", []string{"3", "2"}, 0, true},
+		{"bugs", []string{"3"}, 0, true},
+		{"bugs", nil, 0, true},
+		{"coverage", []string{"80.0", "79.0", ""}, 0, false},
+		{"coverage", []string{"80.0", "", "70.0"}, 0, true},
+		{"coverage", []string{"", ""}, 0, true},
+	}
+	for _, test := range tests {
+		if result := ratchetMetric(test.metric, test.history, test.tolerance); result.Passed != test.passed {
+			t.Errorf("%s %v tolerance %v: expected passed=%v, got %+v", test.metric, test.history, test.tolerance, test.passed, result)
+		}
+	}
+}
+
+// ratchetTestServer mocks three analyses of the main branch, newest first, and
+// answers the measures history with the given pages.
+func ratchetTestServer(t *testing.T, from string, to string, pages ...string) {
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			query := req.URL.Query()
+			body := ""
+			switch req.URL.Path {
+			case projectAnalysesPath:
+				if query.Get("project") != "project" || query.Get("branch") != "main" {
+					t.Errorf("Unexpected request %s", req.URL)
+				}
+				body = `{"paging":{"pageIndex":1,"pageSize":500,"total":3},"analyses":[` +
+					`{"key":"AXlatest","date":"2023-01-03T10:00:00+0000"},` +
+					`{"key":"AXtarget","date":"2023-01-02T10:00:00+0000"},` +
+					`{"key":"AXfirst","date":"2023-01-01T10:00:00+0000"}]}`
+			case measuresHistoryPath:
+				if query.Get("branch") != "main" || query.Get("metrics") != "coverage" || query.Get("from") != from || query.Get("to") != to {
+					t.Errorf("Unexpected request %s", req.URL)
+				}
+				page, _ := strconv.Atoi(query.Get("p"))
+				body = pages[page-1]
+			default:
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+}
+
+func TestCheckRatchet(t *testing.T) {
+	component := url.Values{"component": {"project"}, "branch": {"main"}}
+	ratchetTestServer(t, "2023-01-01T10:00:00+0000", "2023-01-02T10:00:00+0000",
+		`{"paging":{"pageIndex":1,"pageSize":500,"total":501},"measures":[{"metric":"coverage","history":[{"date":"2023-01-01T10:00:00+0000","value":"85.0"}]}]}`,
+		`{"paging":{"pageIndex":2,"pageSize":500,"total":501},"measures":[{"metric":"coverage","history":[{"date":"2023-01-02T10:00:00+0000","value":"84.0"}]}]}`)
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	results, err := checkRatchet(context.Background(), client, []string{"coverage"}, 0, component, "AXtarget")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Passed || results[0].Previous != "85.0" || results[0].Current != "84.0" {
+		t.Errorf("Expected a coverage regression across pages, got %+v", results)
+	}
+
+	// Without an analysis id the latest analysis is compared with the one before it
+	ratchetTestServer(t, "2023-01-02T10:00:00+0000", "2023-01-03T10:00:00+0000",
+		`{"paging":{"pageIndex":1,"pageSize":500,"total":2},"measures":[{"metric":"coverage",`+
+			`"history":[{"date":"2023-01-02T10:00:00+0000","value":"85.0"},{"date":"2023-01-03T10:00:00+0000"}]}]}`)
+	client.HTTPClient = netClient
+	missing, err := checkRatchet(context.Background(), client, []string{"coverage"}, 0, component, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(missing) != 1 || missing[0].Passed || missing[0].Previous != "85.0" || missing[0].Current != "" {
+		t.Errorf("Expected a missing coverage to fail the ratchet, got %+v", missing)
+	}
+
+	// The first analysis has nothing to compare with
+	ratchetTestServer(t, "2023-01-01T10:00:00+0000", "2023-01-01T10:00:00+0000",
+		`{"paging":{"pageIndex":1,"pageSize":500,"total":1},"measures":[{"metric":"coverage","history":[{"date":"2023-01-01T10:00:00+0000","value":"85.0"}]}]}`)
+	client.HTTPClient = netClient
+	first, err := checkRatchet(context.Background(), client, []string{"coverage"}, 0, component, "AXfirst")
+	if err != nil || len(first) != 1 || !first[0].Passed {
+		t.Errorf("Expected the first analysis to pass, got %+v, %v", first, err)
+	}
+
+	if _, err := checkRatchet(context.Background(), client, []string{"coverage"}, 0, component, "AXother"); err == nil {
+		t.Error("Expected an error for an analysis that is not on the branch")
+	}
+
+	gate := evaluateGate(testProject("OK"), nil)
+	gate.applyRatchet(results)
+	if gate.Status != "ERROR" || len(ParseJunit(gate, "project").TestSuite) != 2 {
+		t.Errorf("Expected the regression to fail the gate with a ratchet testsuite, got %+v", gate)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// topIssuesCount is how many new issues the summary reports list.
+const topIssuesCount = 10
+
+// keyMetrics are the measures shown in the summary reports.
+var keyMetrics = []string{
+	"alert_status",
+	"bugs", "new_bugs",
+	"vulnerabilities", "new_vulnerabilities",
+	"security_hotspots", "new_security_hotspots",
+	"code_smells", "new_code_smells",
+	"coverage", "new_coverage",
+	"duplicated_lines_density", "new_duplicated_lines_density",
+	"reliability_rating", "security_rating", "sqale_rating",
+	"ncloc",
+}
+
+// severityRank orders issue severities, most severe first.
+var severityRank = map[string]int{"BLOCKER": 0, "CRITICAL": 1, "MAJOR": 2, "MINOR": 3, "INFO": 4}
+
+// severityOrder returns the rank of a severity, unknown severities last.
+func severityOrder(severity string) int {
+	if rank, ok := severityRank[severity]; ok {
+		return rank
+	}
+	return len(severityRank)
+}
+
+// AnalysisReport gathers what the plugin learned about the analysis, for the
+// summary reports.
+type AnalysisReport struct {
+	ServerURL    string
+	ProjectKey   string
+	Branch       string
+	PullRequest  string
+	DashboardURL string
+	Gate         GateResult
+	Passed       bool   // verdict of the step for the gate status
+	Reason       string // explanation of the verdict
+	Measures     map[string]string
+	Comparison   *MeasureComparison // pull requests only
+	Issues       *IssuesResponse    // open issues, nil when not fetched
+	NewIssues    *IssuesResponse    // open issues of the new code period, nil when not fetched
+}
+
+// newAnalysisReport starts the report of the analysed component.
+func newAnalysisReport(report *SonarReport, serverURL string, component url.Values, gate GateResult, accepted []string) *AnalysisReport {
+	analysis := &AnalysisReport{
+		ServerURL:   strings.TrimRight(serverURL, "/"),
+		ProjectKey:  component.Get("component"),
+		Branch:      component.Get("branch"),
+		PullRequest: component.Get("pullRequest"),
+		Gate:        gate,
+	}
+	analysis.Passed, analysis.Reason = explainGateStatus(gate.Status, accepted)
+	if report != nil && report.DashboardURL != "" {
+		analysis.DashboardURL = report.DashboardURL
+	} else {
+		analysis.DashboardURL = analysis.ServerURL + sonarDashStatic + url.QueryEscape(analysis.ProjectKey) + analysis.scopeQuery()
+	}
+	return analysis
+}
+
+// scopeQuery returns the branch or pull request parameter of the dashboard links.
+func (a *AnalysisReport) scopeQuery() string {
+	if a.PullRequest != "" {
+		return "&pullRequest=" + url.QueryEscape(a.PullRequest)
+	}
+	if a.Branch != "" {
+		return "&branch=" + url.QueryEscape(a.Branch)
+	}
+	return ""
+}
+
+// IssueURL returns the link to an issue on the server.
+func (a *AnalysisReport) IssueURL(issue Issue) string {
+	return a.ServerURL + "/project/issues?id=" + url.QueryEscape(a.ProjectKey) + a.scopeQuery() + "&open=" + url.QueryEscape(issue.Key)
+}
+
+// IssuesURL returns the link to the open issues on the server.
+func (a *AnalysisReport) IssuesURL() string {
+	return a.ServerURL + "/project/issues?id=" + url.QueryEscape(a.ProjectKey) + a.scopeQuery() + "&resolved=false"
+}
+
+// Scope describes the analysed code, such as "branch main".
+func (a *AnalysisReport) Scope() string {
+	if a.PullRequest != "" {
+		return "pull request " + a.PullRequest
+	}
+	if a.Branch != "" {
+		return "branch " + a.Branch
+	}
+	return "main branch"
+}
+
+// fetchMeasures reads the key measures of the analysed component.
+func (a *AnalysisReport) fetchMeasures(ctx context.Context, client *SonarClient, component url.Values) error {
+	measures, err := client.Measures(ctx, component, keyMetrics)
+	if err != nil {
+		return fmt.Errorf("failed to get the measures of the report: %w", err)
+	}
+	a.Measures = measures.Values()
+	return nil
+}
+
+// sortIssues returns the issues ordered by severity, then file and line.
+func sortIssues(issues []Issue) []Issue {
+	sorted := append([]Issue{}, issues...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if severityOrder(sorted[i].Severity) != severityOrder(sorted[j].Severity) {
+			return severityOrder(sorted[i].Severity) < severityOrder(sorted[j].Severity)
+		}
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		return sorted[i].Line < sorted[j].Line
+	})
+	return sorted
+}
+
+// Markdown renders the summary report for pull request comments and step summaries.
+func (a *AnalysisReport) Markdown() string {
+	var b strings.Builder
+	icon := ":white_check_mark:"
+	if !a.Passed {
+		icon = ":x:"
+	}
+	fmt.Fprintf(&b, "## %s SonarQube quality gate: %s\n\n", icon, a.Gate.Status)
+	fmt.Fprintf(&b, "**Project:** [%s](%s) (%s)", a.ProjectKey, a.DashboardURL, a.Scope())
+	if name := a.Gate.Name(); name != "" {
+		fmt.Fprintf(&b, " · **Quality gate:** %s", name)
+	}
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "%s\n\n", a.Reason)
+	if a.Gate.ServerStatus != a.Gate.Status {
+		fmt.Fprintf(&b, "The server status is %s, the plugin settings changed it to %s.\n\n", a.Gate.ServerStatus, a.Gate.Status)
+	}
+	if a.Gate.Period != nil {
+		fmt.Fprintf(&b, "New code: %s (since %s).\n\n", a.Gate.Period.Description(), valueOrDash(a.Gate.Period.Date))
+	}
+
+	failed, passed := []GateCondition{}, []GateCondition{}
+	for _, condition := range a.Gate.Conditions {
+		if condition.Verdict == verdictOK || condition.Verdict == verdictIgnored || condition.Overridden() {
+			passed = append(passed, condition)
+		} else {
+			failed = append(failed, condition)
+		}
+	}
+	writeConditions := func(title string, conditions []GateCondition) {
+		if len(conditions) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "### %s\n\n", title)
+		b.WriteString("| Metric | Actual | Condition | Status |\n|--------|--------|-----------|--------|\n")
+		for _, condition := range conditions {
+			fmt.Fprintf(&b, "| %s | %s | %s %s | %s |\n", condition.MetricKey, valueOrDash(condition.ActualValue),
+				condition.Comparator, condition.ErrorThreshold, condition.Verdict)
+		}
+		b.WriteString("\n")
+	}
+	writeConditions("Failed conditions", failed)
+	writeConditions("Passed conditions", passed)
+
+	failedThresholds := a.Gate.FailedThresholds()
+	regressions := a.Gate.Regressions()
+	if len(failedThresholds) > 0 || len(regressions) > 0 {
+		b.WriteString("### Plugin checks\n\n")
+		for _, threshold := range failedThresholds {
+			fmt.Fprintf(&b, "- :x: `%s`: %s\n", threshold.Threshold.String(), threshold.Message)
+		}
+		for _, regression := range regressions {
+			fmt.Fprintf(&b, "- :x: %s\n", regression.Message)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(a.Measures) > 0 {
+		b.WriteString("### Measures\n\n| Metric | Value |\n|--------|-------|\n")
+		for _, metric := range keyMetrics {
+			if value, ok := a.Measures[metric]; ok {
+				fmt.Fprintf(&b, "| %s | %s |\n", metric, value)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if a.Comparison != nil {
+		fmt.Fprintf(&b, "### Compared to %s\n\n%s\n", a.Comparison.Target, a.Comparison.Markdown())
+	}
+
+	if a.NewIssues != nil {
+		issues := sortIssues(a.NewIssues.Issues)
+		fmt.Fprintf(&b, "### New issues (%d)\n\n", a.NewIssues.Total)
+		if len(issues) == 0 {
+			b.WriteString("No new issues.\n\n")
+		} else {
+			if len(issues) > topIssuesCount {
+				issues = issues[:topIssuesCount]
+			}
+			b.WriteString("| Severity | Type | Issue | Location |\n|----------|------|-------|----------|\n")
+			for _, issue := range issues {
+				location := valueOrDash(issue.File)
+				if issue.Line > 0 {
+					location = fmt.Sprintf("%s:%d", issue.File, issue.Line)
+				}
+				fmt.Fprintf(&b, "| %s | %s | [%s](%s) | `%s` |\n", issue.Severity, issue.Type,
+					markdownEscape(issue.Message), a.IssueURL(issue), location)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(&b, "[Dashboard](%s) · [Issues](%s)\n", a.DashboardURL, a.IssuesURL())
+	return b.String()
+}
+
+// exportMarkdownReport completes the report with the measures and new issues
+// and writes the markdown summary to path.
+func exportMarkdownReport(ctx context.Context, client *SonarClient, path string, analysis *AnalysisReport, issueSource *issueSearch, component url.Values) error {
+	if err := analysis.fetchMeasures(ctx, client, component); err != nil {
+		return err
+	}
+	newIssues, err := issueSource.get(ctx, true)
+	if err != nil {
+		return err
+	}
+	analysis.NewIssues = newIssues
+	return writeMarkdownReport(path, analysis)
+}
+
+// writeMarkdownReport writes the summary report to path.
+func writeMarkdownReport(path string, analysis *AnalysisReport) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating report directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(analysis.Markdown()), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Printf("==> Markdown report written to %s\n\n", path)
+	return nil
+}
+
+// markdownEscape keeps issue messages from breaking the markdown tables.
+func markdownEscape(text string) string {
+	replacer := strings.NewReplacer("|", "\\|", "\n", " ", "[", "\\[", "]", "\\]")
+	return replacer.Replace(text)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testAnalysisReport() *AnalysisReport {
+	gate := evaluateGate(testProject("ERROR",
+		Condition{Status: "ERROR", MetricKey: "new_coverage", Comparator: "LT", ErrorThreshold: "80", ActualValue: "62.5"},
+		Condition{Status: "OK", MetricKey: "new_bugs", Comparator: "GT", ErrorThreshold: "0", ActualValue: "0"},
+	), nil)
+	gate.Definition = &QualityGateDefinition{Name: "Sonar way"}
+	analysis := newAnalysisReport(nil, "http://sonar/", url.Values{"component": {"project"}, "pullRequest": {"42"}}, gate, []string{"OK"})
+	analysis.Measures = map[string]string{"coverage": "71.0", "new_coverage": "62.5"}
+	analysis.NewIssues = &IssuesResponse{Total: 2, Issues: []Issue{
+		{Key: "I1", Severity: "MINOR", Type: "CODE_SMELL", File: "a.go", Line: 3, Message: "Rename | this"},
+		{Key: "I2", Severity: "BLOCKER", Type: "BUG", File: "b.go", Line: 7, Message: "Fix this"},
+	}}
+	return analysis
+}
+
+func TestAnalysisReportMarkdown(t *testing.T) {
+	analysis := testAnalysisReport()
+	if analysis.DashboardURL != "http://sonar/dashboard?id=project&pullRequest=42" {
+		t.Errorf("Unexpected dashboard URL %s", analysis.DashboardURL)
+	}
+
+	markdown := analysis.Markdown()
+	expected := []string{
+		"## :x: SonarQube quality gate: ERROR",
+		"[project](http://sonar/dashboard?id=project&pullRequest=42) (pull request 42) · **Quality gate:** Sonar way",
+		"### Failed conditions\n\n| Metric | Actual | Condition | Status |\n|--------|--------|-----------|--------|\n| new_coverage | 62.5 | LT 80 | ERROR |",
+		"### Passed conditions",
+		"| coverage | 71.0 |",
+		"### New issues (2)",
+		"| BLOCKER | BUG | [Fix this](http://sonar/project/issues?id=project&pullRequest=42&open=I2) | `b.go:7` |",
+		"Rename \\| this",
+		"[Issues](http://sonar/project/issues?id=project&pullRequest=42&resolved=false)",
+	}
+	for _, text := range expected {
+		if !strings.Contains(markdown, text) {
+			t.Errorf("Expected %q in\n%s", text, markdown)
+		}
+	}
+	if strings.Index(markdown, "Fix this") > strings.Index(markdown, "Rename") {
+		t.Error("Expected the most severe issues first")
+	}
+}
+
+func TestSortIssues(t *testing.T) {
+	sorted := sortIssues([]Issue{
+		{Key: "I1", Severity: "HIGH", File: "a.go"},
+		{Key: "I2", Severity: "INFO", File: "b.go"},
+		{Key: "I3", Severity: "", File: "a.go"},
+		{Key: "I4", Severity: "BLOCKER", File: "c.go"},
+	})
+	keys := []string{}
+	for _, issue := range sorted {
+		keys = append(keys, issue.Key)
+	}
+	if strings.Join(keys, ",") != "I4,I2,I1,I3" {
+		t.Errorf("Expected unknown severities after INFO, got %v", keys)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type (
+	// SarifLog is the root of a SARIF 2.1.0 document.
+	SarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []SarifRun `json:"runs"`
+	}
+	SarifRun struct {
+		Tool    SarifTool     `json:"tool"`
+		Results []SarifResult `json:"results"`
+	}
+	SarifTool struct {
+		Driver SarifDriver `json:"driver"`
+	}
+	SarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri,omitempty"`
+		Version        string      `json:"version,omitempty"`
+		Rules          []SarifRule `json:"rules"`
+	}
+	SarifRule struct {
+		ID               string        `json:"id"`
+		Name             string        `json:"name,omitempty"`
+		ShortDescription *SarifMessage `json:"shortDescription,omitempty"`
+	}
+	SarifResult struct {
+		RuleID     string            `json:"ruleId"`
+		RuleIndex  int               `json:"ruleIndex"`
+		Level      string            `json:"level"`
+		Message    SarifMessage      `json:"message"`
+		Locations  []SarifLocation   `json:"locations,omitempty"`
+		Properties map[string]string `json:"properties,omitempty"`
+	}
+	SarifMessage struct {
+		Text string `json:"text"`
+	}
+	SarifLocation struct {
+		PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+	}
+	SarifPhysicalLocation struct {
+		ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+		Region           *SarifRegion          `json:"region,omitempty"`
+	}
+	SarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId,omitempty"`
+	}
+	SarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+		EndLine     int `json:"endLine,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+)
+
+// sarifLevel maps a SonarQube severity to a SARIF result level.
+func sarifLevel(severity string) string {
+	switch severity {
+	case "BLOCKER", "CRITICAL":
+		return "error"
+	case "MAJOR":
+		return "warning"
+	}
+	return "note"
+}
+
+// buildSarif converts the issues to a SARIF log with one run for SonarQube.
+// Paths are relative to the project base directory (%SRCROOT%).
+func buildSarif(issues *IssuesResponse, serverURL string, serverVersion string) SarifLog {
+	driver := SarifDriver{Name: "SonarQube", InformationURI: serverURL, Version: serverVersion, Rules: []SarifRule{}}
+	ruleIndex := map[string]int{}
+	results := []SarifResult{}
+
+	for _, issue := range issues.Issues {
+		index, ok := ruleIndex[issue.Rule]
+		if !ok {
+			index = len(driver.Rules)
+			ruleIndex[issue.Rule] = index
+			rule := SarifRule{ID: issue.Rule}
+			if name := issues.RuleName(issue.Rule); name != issue.Rule {
+				rule.Name = name
+				rule.ShortDescription = &SarifMessage{Text: name}
+			}
+			driver.Rules = append(driver.Rules, rule)
+		}
+
+		result := SarifResult{
+			RuleID:    issue.Rule,
+			RuleIndex: index,
+			Level:     sarifLevel(issue.Severity),
+			Message:   SarifMessage{Text: issue.Message},
+			Properties: map[string]string{
+				"issueKey": issue.Key,
+				"severity": issue.Severity,
+				"type":     issue.Type,
+			},
+		}
+		if issue.File != "" {
+			location := SarifPhysicalLocation{ArtifactLocation: SarifArtifactLocation{URI: issue.File, URIBaseID: "%SRCROOT%"}}
+			if issue.TextRange != nil && issue.TextRange.StartLine > 0 {
+				// SonarQube offsets are 0-based, SARIF columns 1-based
+				location.Region = &SarifRegion{
+					StartLine:   issue.TextRange.StartLine,
+					StartColumn: issue.TextRange.StartOffset + 1,
+					EndLine:     issue.TextRange.EndLine,
+					EndColumn:   issue.TextRange.EndOffset + 1,
+				}
+			} else if issue.Line > 0 {
+				location.Region = &SarifRegion{StartLine: issue.Line}
+			}
+			result.Locations = []SarifLocation{{PhysicalLocation: location}}
+		}
+		results = append(results, result)
+	}
+
+	return SarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []SarifRun{{Tool: SarifTool{Driver: driver}, Results: results}},
+	}
+}
+
+// exportSarif writes the open issues of the analysed component as SARIF to path.
+func exportSarif(ctx context.Context, path string, issueSource *issueSearch, serverURL string, serverVersion string) error {
+	issues, err := issueSource.get(ctx, false)
+	if err != nil {
+		return err
+	}
+	return writeSarif(path, issues, serverURL, serverVersion)
+}
+
+// writeSarif writes the issues as SARIF 2.1.0 to path.
+func writeSarif(path string, issues *IssuesResponse, serverURL string, serverVersion string) error {
+	data, err := json.MarshalIndent(buildSarif(issues, serverURL, serverVersion), "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating report directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Printf("==> SARIF report with %d issues written to %s\n\n", len(issues.Issues), path)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSearchIssuesPaging(t *testing.T) {
+	pages := 0
+	netClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			query := req.URL.Query()
+			if req.URL.Path != issuesSearchPath || query.Get("componentKeys") != "project" || query.Get("pullRequest") != "7" || query.Get("resolved") != "false" {
+				t.Errorf("Unexpected request %s", req.URL)
+			}
+			pages++
+			body := `{"paging":{"pageIndex":1,"pageSize":500,"total":501},` +
+				`"issues":[{"key":"I1","rule":"go:S1234","severity":"CRITICAL","type":"BUG","component":"project:src/main.go","line":12,` +
+				`"textRange":{"startLine":12,"endLine":12,"startOffset":4,"endOffset":18},"message":"Fix this"}],` +
+				`"components":[{"key":"project:src/main.go","path":"src/main.go","qualifier":"FIL"}],` +
+				`"rules":[{"key":"go:S1234","name":"Bugs should be fixed"}]}`
+			if query.Get("p") == "2" {
+				body = `{"paging":{"pageIndex":2,"pageSize":500,"total":501},` +
+					`"issues":[{"key":"I2","rule":"go:S100","severity":"MINOR","type":"CODE_SMELL","component":"project","message":"Project level"}],` +
+					`"components":[{"key":"project","qualifier":"TRK"}]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			}
+		}),
+	}
+
+	client := NewSonarClient("http://sonar", "token")
+	client.AuthScheme = authSchemeBasic
+	issues, err := searchIssues(context.Background(), client, url.Values{"component": {"project"}, "pullRequest": {"7"}}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pages != 2 || len(issues.Issues) != 2 || issues.Issues[0].File != "src/main.go" || issues.Issues[1].File != "" {
+		t.Fatalf("Unexpected issues after %d pages: %+v", pages, issues.Issues)
+	}
+
+	path := filepath.Join(t.TempDir(), "reports", "sonar.sarif")
+	if err := writeSarif(path, issues, "http://sonar", "9.9"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	var log SarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("Invalid SARIF: %v", err)
+	}
+	run := log.Runs[0]
+	if log.Version != "2.1.0" || len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].Name != "Bugs should be fixed" {
+		t.Errorf("Unexpected SARIF tool %+v", run.Tool)
+	}
+	first := run.Results[0]
+	region := first.Locations[0].PhysicalLocation.Region
+	if first.RuleID != "go:S1234" || first.Level != "error" || first.Message.Text != "Fix this" ||
+		first.Locations[0].PhysicalLocation.ArtifactLocation.URI != "src/main.go" ||
+		region.StartLine != 12 || region.StartColumn != 5 || region.EndColumn != 19 {
+		t.Errorf("Unexpected SARIF result %+v", first)
+	}
+	if second := run.Results[1]; second.Level != "note" || second.RuleIndex != 1 || len(second.Locations) != 0 {
+		t.Errorf("Unexpected project level result %+v", second)
+	}
+}
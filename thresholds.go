@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Threshold operators of metric_thresholds, two character ones first so
+// "coverage>=80" is not read as "coverage>" "=80".
+var thresholdOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+type (
+	// Threshold is a local requirement on a metric, such as coverage>=80.
+	Threshold struct {
+		Metric   string
+		Operator string
+		Value    string
+	}
+
+	// ThresholdResult is a Threshold checked against the analysis measures.
+	ThresholdResult struct {
+		Threshold
+		ActualValue string
+		Passed      bool
+		Message     string
+	}
+)
+
+func (t Threshold) String() string {
+	return t.Metric + t.Operator + t.Value
+}
+
+// parseThresholds reads metric_thresholds, a comma separated list such as
+// "coverage>=80,new_bugs==0,sqale_rating<=B". Ratings may be given as A-E.
+func parseThresholds(value string) ([]Threshold, error) {
+	thresholds := []Threshold{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		threshold, err := parseThreshold(entry)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+func parseThreshold(entry string) (Threshold, error) {
+	for _, operator := range thresholdOperators {
+		index := strings.Index(entry, operator)
+		if index < 0 {
+			continue
+		}
+		threshold := Threshold{
+			Metric:   strings.TrimSpace(entry[:index]),
+			Operator: operator,
+			Value:    strings.TrimSpace(entry[index+len(operator):]),
+		}
+		if threshold.Metric == "" || threshold.Value == "" {
+			break
+		}
+		if rating, ok := ratingValue(threshold.Value); ok && strings.HasSuffix(threshold.Metric, "_rating") {
+			threshold.Value = rating
+		}
+		return threshold, nil
+	}
+	return Threshold{}, fmt.Errorf("metric_thresholds entry %q must look like metric>=value (operators %s): %w",
+		entry, strings.Join(thresholdOperators, " "), ErrConfig)
+}
+
+// ratingValue converts a rating letter to the number SonarQube stores, A is 1 and E is 5.
+func ratingValue(value string) (string, bool) {
+	if len(value) != 1 {
+		return "", false
+	}
+	letter := strings.ToUpper(value)[0]
+	if letter < 'A' || letter > 'E' {
+		return "", false
+	}
+	return strconv.Itoa(int(letter-'A') + 1), true
+}
+
+// thresholdMetrics returns the metric keys to fetch for the thresholds.
+func thresholdMetrics(thresholds []Threshold) []string {
+	seen := map[string]bool{}
+	metrics := []string{}
+	for _, threshold := range thresholds {
+		if !seen[threshold.Metric] {
+			seen[threshold.Metric] = true
+			metrics = append(metrics, threshold.Metric)
+		}
+	}
+	return metrics
+}
+
+// evaluateThresholds checks every threshold against the measures, by metric key.
+// A metric without a value fails its threshold.
+func evaluateThresholds(thresholds []Threshold, measures map[string]string) []ThresholdResult {
+	results := []ThresholdResult{}
+	for _, threshold := range thresholds {
+		result := ThresholdResult{Threshold: threshold}
+		actual, ok := measures[threshold.Metric]
+		if !ok || actual == "" {
+			result.Message = "no value for " + threshold.Metric + " in this analysis"
+			results = append(results, result)
+			continue
+		}
+		result.ActualValue = actual
+		passed, err := compareThreshold(actual, threshold.Operator, threshold.Value)
+		result.Passed = passed
+		if err != nil {
+			result.Message = err.Error()
+		} else if passed {
+			result.Message = actual + " " + threshold.Operator + " " + threshold.Value
+		} else {
+			result.Message = "Violated: " + actual + " is not " + threshold.Operator + " " + threshold.Value
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// compareThreshold compares numerically when both sides are numbers, otherwise
+// only == and != are allowed, as a plain string comparison.
+func compareThreshold(actual string, operator string, expected string) (bool, error) {
+	actualNumber, errActual := strconv.ParseFloat(actual, 64)
+	expectedNumber, errExpected := strconv.ParseFloat(expected, 64)
+	if errActual != nil || errExpected != nil {
+		switch operator {
+		case "==":
+			return actual == expected, nil
+		case "!=":
+			return actual != expected, nil
+		}
+		return false, fmt.Errorf("cannot compare %q %s %q, values are not numbers", actual, operator, expected)
+	}
+
+	switch operator {
+	case ">=":
+		return actualNumber >= expectedNumber, nil
+	case "<=":
+		return actualNumber <= expectedNumber, nil
+	case "==":
+		return actualNumber == expectedNumber, nil
+	case "!=":
+		return actualNumber != expectedNumber, nil
+	case ">":
+		return actualNumber > expectedNumber, nil
+	case "<":
+		return actualNumber < expectedNumber, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", operator)
+}
+
+// displayThresholds prints the result of every local metric threshold.
+func displayThresholds(results []ThresholdResult) {
+	if len(results) == 0 {
+		return
+	}
+	fmt.Println(lineBreak2)
+	fmt.Println("|  LOCAL METRIC THRESHOLDS                                       |")
+	fmt.Println(lineBreak2)
+	for _, result := range results {
+		status := "\033[32mPASSED\033[0m"
+		if !result.Passed {
+			status = "\033[31mFAILED\033[0m"
+		}
+		fmt.Printf("| %-34s | %-12s | %s |\n", result.Threshold.String(), result.ActualValue, status)
+	}
+	fmt.Println(lineBreak2)
+	fmt.Println("")
+}
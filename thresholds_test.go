@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := parseThresholds("coverage>=80, new_bugs==0,sqale_rating<=b,,alert_status!=ERROR")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Threshold{
+		{Metric: "coverage", Operator: ">=", Value: "80"},
+		{Metric: "new_bugs", Operator: "==", Value: "0"},
+		{Metric: "sqale_rating", Operator: "<=", Value: "2"},
+		{Metric: "alert_status", Operator: "!=", Value: "ERROR"},
+	}
+	if len(thresholds) != len(expected) {
+		t.Fatalf("Expected %d thresholds, got %+v", len(expected), thresholds)
+	}
+	for i := range expected {
+		if thresholds[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], thresholds[i])
+		}
+	}
+
+	for _, value := range []string{"coverage", ">=80", "coverage>="} {
+		if _, err := parseThresholds(value); !errors.Is(err, ErrConfig) {
+			t.Errorf("Expected ErrConfig for %q, got %v", value, err)
+		}
+	}
+}
+
+func TestEvaluateThresholds(t *testing.T) {
+	thresholds, _ := parseThresholds("coverage>=80,new_bugs==0,sqale_rating<=B,ncloc<1000,alert_status==OK")
+	results := evaluateThresholds(thresholds, map[string]string{
+		"coverage":     "80.0",
+		"new_bugs":     "1",
+		"sqale_rating": "2.0",
+		"alert_status": "OK",
+	})
+
+	passed := map[string]bool{}
+	for _, result := range results {
+		passed[result.Metric] = result.Passed
+	}
+	expected := map[string]bool{"coverage": true, "new_bugs": false, "sqale_rating": true, "ncloc": false, "alert_status": true}
+	for metric, want := range expected {
+		if passed[metric] != want {
+			t.Errorf("Expected %s passed=%v, got %v", metric, want, passed[metric])
+		}
+	}
+
+	gate := evaluateGate(testProject("OK"), map[string]string{})
+	gate.applyThresholds(results)
+	if gate.Status != "ERROR" || len(gate.FailedThresholds()) != 2 {
+		t.Errorf("Expected failed thresholds to fail the gate, got %s %+v", gate.Status, gate.FailedThresholds())
+	}
+
+	report := ParseJunit(gate, "project")
+	if len(report.TestSuite) != 2 || report.TestSuite[1].Tests != 5 || report.TestSuite[1].Errors != 2 {
+		t.Errorf("Expected a thresholds testsuite with 5 tests and 2 errors, got %+v", report.TestSuite)
+	}
+}